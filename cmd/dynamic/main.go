@@ -111,7 +111,8 @@ func main() {
 }
 
 func Update() {
-	// Executet after every scene change
-	bvh := scene.CompileLBVH()
+	// Executet after every scene change. Only the moved spheres are updated,
+	// the BVH is rebuilt once its cost is 50% worse than after the last build
+	bvh := scene.Refit(renderer.GetBvh(), 1.5, (*pt.Scene).CompileLBVH)
 	renderer.SetBvh(bvh)
 }
@@ -37,6 +37,9 @@ func main() {
 		// Sky miss shader adds ambient light to all misses and adds a "sky" color interpolation
 		renderer.Miss = SkyMissShader
 
+		// Sample emissive primitives directly at each bounce, which reduces noise from small lights
+		renderer.Closest = LightSamplingShader
+
 		// Specify samples-per-pixel and max depth
 		renderer.Spp = 100
 		renderer.MaxDepth = 5
@@ -49,6 +52,9 @@ func main() {
 			buff := NewPxlBufferAR(RESOLUTION, ASPECT_RATIO)
 			renderer.RenderToBuffer(buff)
 
+			// Compress bright highlights instead of clipping them when converting to 8 bit
+			buff.PostProcess.ToneMap = ACESToneMapper
+
 			// Write image to specified path
 			path := fmt.Sprintf("%v_%v.png", world.Name, i)
 			f, err := os.Create(path)
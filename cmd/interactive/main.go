@@ -26,5 +26,7 @@ func main() {
 	renderer := pt.NewRealtimeRenderer(bvh, camera)
 	cameraVelocity := 1.5
 	runtime := app.NewInteractiveRuntime(renderer, ASPECT_RATIO, FOV, cameraVelocity, RESOLUTION)
+	denoiser := pt.DefaultDenoiser()
+	runtime.SetDenoiser(&denoiser)
 	runtime.Run(nil)
 }
@@ -1,11 +1,13 @@
 package benchmark
 
 import (
+	"context"
 	"fmt"
 	"github/chschmidt99/pt/pkg/demoscenes"
 	"github/chschmidt99/pt/pkg/pt"
 	"runtime"
 	"testing"
+	"time"
 )
 
 const (
@@ -34,12 +36,29 @@ func BenchmarkScene(b *testing.B) {
 	// Specify branching factor of BVH
 	branching := 2
 	benchLBVH(b, world, resolutions)
+	benchSAH(b, branching, world, resolutions)
+	benchSBVH(b, branching, world, resolutions)
 	benchFullPHR(b, "PHR_Fast", PHR_FAST_ALPHA, PHR_FAST_DELTA, branching, world, resolutions)
 	benchFullPHR(b, "PHR_HQ", PHR_HQ_ALPHA, PHR_HQ_DELTA, branching, world, resolutions)
 	benchGridSearch(b, branching, world, resolutions)
 	benchBayOp(b, branching, world, resolutions)
 }
 
+// Compares the traversal of the pointer based BVH with its flattened 4 and 8 wide layouts
+func BenchmarkWideBVH(b *testing.B) {
+	world := demoscenes.Fireplace()
+	resolution := 256
+	primitives := world.Scene.UntransformedTracables()
+	builder := pt.NewSAHBuilder(primitives, pt.DEFAULT_SAH_BINS, pt.DEFAULT_SAH_LEAF_SIZE, 2, runtime.GOMAXPROCS(0))
+	bvh := builder.Build()
+	benchTraversal(b, "pointer", bvh, world, resolution)
+	for _, width := range []int{4, 8} {
+		wide := builder.Build()
+		wide.Flatten(width)
+		benchTraversal(b, fmt.Sprintf("bvh%v", width), wide, world, resolution)
+	}
+}
+
 func benchLBVH(b *testing.B, world demoscenes.DemoScene, resolutions []int) {
 	bvh := benchBuildLBVH(b, world)
 	for _, resolution := range resolutions {
@@ -107,6 +126,43 @@ func benchBuildPHR(b *testing.B, name string, branching int, alpha, delta float6
 	return bvh
 }
 
+func benchSAH(b *testing.B, branching int, world demoscenes.DemoScene, resolutions []int) {
+	bvh := benchBuildSAH(b, branching, world)
+	for _, resolution := range resolutions {
+		benchRender(b, "sah", bvh, world, resolution)
+	}
+}
+
+func benchBuildSAH(b *testing.B, branching int, world demoscenes.DemoScene) pt.BVH {
+	var bvh pt.BVH
+	primitives := world.Scene.UntransformedTracables()
+	builder := pt.NewSAHBuilder(primitives, pt.DEFAULT_SAH_BINS, pt.DEFAULT_SAH_LEAF_SIZE, branching, runtime.GOMAXPROCS(0))
+	b.Run("build_sah_"+world.Name, func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bvh = builder.Build()
+		}
+	})
+	b.Logf("sah cost %v: %v", world.Name, bvh.Cost())
+	return bvh
+}
+
+func benchSBVH(b *testing.B, branching int, world demoscenes.DemoScene, resolutions []int) {
+	var bvh pt.BVH
+	primitives := world.Scene.UntransformedTracables()
+	builder := pt.NewSBVHBuilder(primitives, pt.DEFAULT_SAH_BINS, pt.DEFAULT_SAH_LEAF_SIZE, branching, pt.DEFAULT_SBVH_DUPLICATION, runtime.GOMAXPROCS(0))
+	b.Run("build_sbvh_"+world.Name, func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bvh = builder.Build()
+		}
+	})
+	b.Logf("sbvh cost %v: %v, duplicated references: %v", world.Name, bvh.Cost(), builder.Duplicated())
+	for _, resolution := range resolutions {
+		benchRender(b, "sbvh", bvh, world, resolution)
+	}
+}
+
 func benchBuildLBVH(b *testing.B, world demoscenes.DemoScene) pt.BVH {
 	var bvh pt.BVH
 	primitives := world.Scene.UntransformedTracables()
@@ -136,3 +192,28 @@ func benchRender(b *testing.B, name string, bvh pt.BVH, world demoscenes.DemoSce
 	//}
 
 }
+
+// Renders like benchRender and reports the traced rays per second
+func benchTraversal(b *testing.B, name string, bvh pt.BVH, world demoscenes.DemoScene, frameSize int) {
+	buff := pt.NewFrameBufferAR(frameSize, AR)
+	camera := pt.NewDefaultCamera(AR, FOV)
+	renderer := pt.NewBenchmarkRenderer(bvh, camera)
+	camera.SetTransformation(world.ViewPoints[0])
+	n := fmt.Sprintf(name+"_traversal_%v_%vx%v", world.Name, frameSize, frameSize)
+	b.Run(n, func(b *testing.B) {
+		rays := uint64(0)
+		opts := pt.RenderOptions{
+			Progress: func(p pt.Progress) {
+				if p.Pass == p.Passes-1 {
+					rays += p.Rays
+				}
+			},
+		}
+		b.ResetTimer()
+		start := time.Now()
+		for i := 0; i < b.N; i++ {
+			renderer.RenderContext(context.Background(), buff, opts)
+		}
+		b.ReportMetric(float64(rays)/time.Since(start).Seconds()/1e6, "Mrays/s")
+	})
+}
@@ -3,9 +3,8 @@ package demoscenes
 import . "github/chschmidt99/pt/pkg/pt"
 
 func Breakfast() DemoScene {
-	geometry := ParseFromPath("../../assets/local/breakfast/breakfast_room.obj")
 	whiteMat := Diffuse{Albedo: NewColor(.73, .73, .73)}
-	root := NewSceneNode(NewMesh(geometry, whiteMat))
+	root := ParseSceneFromPath("../../assets/local/breakfast/breakfast_room.obj", whiteMat)
 	scene := NewScene()
 	scene.Add(root)
 	views := []CameraTransformation{
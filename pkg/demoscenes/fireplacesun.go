@@ -3,9 +3,8 @@ package demoscenes
 import . "github/chschmidt99/pt/pkg/pt"
 
 func Fireplace() DemoScene {
-	geometry := ParseFromPath("../../assets/local/fireplace/fireplace_room_window.obj")
 	whiteMat := Diffuse{Albedo: NewColor(.73, .73, .73)}
-	root := NewSceneNode(NewMesh(geometry, whiteMat))
+	root := ParseSceneFromPath("../../assets/local/fireplace/fireplace_room_window.obj", whiteMat)
 	scene := NewScene()
 	scene.Add(root)
 
@@ -3,15 +3,13 @@ package demoscenes
 import . "github/chschmidt99/pt/pkg/pt"
 
 func SanMiguel() DemoScene {
-	geometry := ParseFromPath("../../assets/local/san_miguel/san-miguel-low-poly.obj")
-
 	//sphere := NewSphere(NewVector3(15, 25, 5), 6)
 
-	//geometry := ParseFromPath("../../assets/local/san_miguel/san-miguel.obj")
 	whiteMat := Diffuse{Albedo: NewColor(.83, .83, .83)}
 	//light := Light{Color: NewColor(10, 6.5, 3)}
 
-	root := NewSceneNode(NewMesh(geometry, whiteMat))
+	root := ParseSceneFromPath("../../assets/local/san_miguel/san-miguel-low-poly.obj", whiteMat)
+	//root := ParseSceneFromPath("../../assets/local/san_miguel/san-miguel.obj", whiteMat)
 	//sun := NewSceneNode(NewMesh(Geometry{sphere}, light))
 	scene := NewScene()
 	scene.Add(root)
@@ -3,12 +3,10 @@ package demoscenes
 import . "github/chschmidt99/pt/pkg/pt"
 
 func SanMiguelSun() DemoScene {
-	geometry := ParseFromPath("../../assets/local/san_miguel/san-miguel-low-poly.obj")
-
-	//geometry := ParseFromPath("../../assets/local/san_miguel/san-miguel.obj")
 	whiteMat := Diffuse{Albedo: NewColor(.83, .83, .83)}
 
-	root := NewSceneNode(NewMesh(geometry, whiteMat))
+	root := ParseSceneFromPath("../../assets/local/san_miguel/san-miguel-low-poly.obj", whiteMat)
+	//root := ParseSceneFromPath("../../assets/local/san_miguel/san-miguel.obj", whiteMat)
 	scene := NewScene()
 	scene.Add(root)
 
@@ -3,9 +3,8 @@ package demoscenes
 import . "github/chschmidt99/pt/pkg/pt"
 
 func Sibenik() DemoScene {
-	geometry := ParseFromPath("../../assets/local/sibenik/sibenik.obj")
 	whiteMat := Diffuse{Albedo: NewColor(.73, .73, .73)}
-	root := NewSceneNode(NewMesh(geometry, whiteMat))
+	root := ParseSceneFromPath("../../assets/local/sibenik/sibenik.obj", whiteMat)
 	scene := NewScene()
 	scene.Add(root)
 	views := []CameraTransformation{
@@ -3,10 +3,9 @@ package demoscenes
 import . "github/chschmidt99/pt/pkg/pt"
 
 func Sponza() DemoScene {
-	geometry := ParseFromPath("../../assets/local/sponza/sponza.obj")
 	whiteMat := Diffuse{Albedo: NewColor(.73, .73, .73)}
 
-	sponza := NewSceneNode(NewMesh(geometry, whiteMat))
+	sponza := ParseSceneFromPath("../../assets/local/sponza/sponza.obj", whiteMat)
 	scene := NewScene()
 	scene.Add(sponza)
 
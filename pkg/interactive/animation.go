@@ -30,6 +30,13 @@ func (a Animation) t(t time.Time) float64 {
 	return t.Sub(a.startingTime).Seconds() / a.path.duration().Seconds()
 }
 
+// Places node on path at from and lets it move to the position at to during the shutter interval, see pt.SceneNode.SetMotion.
+// The motion in between is a straight line, waypoints of sequences are skipped
+func SetMotionFromPath(node *pt.SceneNode, path Path, from, to float64) {
+	node.SetTransformation(path.transformationAtTime(from))
+	node.SetMotion(path.transformationAtTime(to))
+}
+
 type animationScheduler struct {
 	queue animationQueue
 	t     time.Time
@@ -29,6 +29,9 @@ type Runtime struct {
 
 	scheduler *animationScheduler
 	onRender  func()
+
+	denoiser *pt.Denoiser
+	aovs     *pt.AOVBuffer // guide buffers of the denoiser, rendered instead of the window buffer
 }
 
 // scene: Scene to be rendered, camera has to be of type RealTimeCamera; BVH already calculated
@@ -62,6 +65,15 @@ func (r *Runtime) Run(onRender func()) {
 
 }
 
+// Renders the frames with albedo, normal and depth guides and filters them with denoiser, nil disables denoising
+func (r *Runtime) SetDenoiser(denoiser *pt.Denoiser) {
+	r.denoiser = denoiser
+	r.aovs = nil
+	if denoiser != nil {
+		r.aovs = pt.NewAOVBuffer(r.window.buffer.Width(), r.window.buffer.Height())
+	}
+}
+
 func (r *Runtime) AddAnimation(a *Animation) {
 	r.scheduler.queueAnimation(a)
 }
@@ -120,7 +132,19 @@ func (runtime *Runtime) update(window *Window) {
 	if runtime.onRender != nil {
 		runtime.onRender()
 	}
-	runtime.renderer.RenderToBuffer(window.buffer)
+	if runtime.denoiser != nil {
+		if runtime.aovs.Width() != window.buffer.Width() || runtime.aovs.Height() != window.buffer.Height() {
+			// The window was resized
+			runtime.aovs = pt.NewAOVBuffer(window.buffer.Width(), window.buffer.Height())
+		}
+		runtime.aovs.Clear()
+		runtime.renderer.RenderToBuffer(runtime.aovs)
+		if err := runtime.denoiser.DenoiseTo(runtime.aovs, window.buffer); err != nil {
+			fmt.Println(err)
+		}
+	} else {
+		runtime.renderer.RenderToBuffer(window.buffer)
+	}
 	window.Refresh()
 	runtime.frameCounter++
 }
@@ -0,0 +1,103 @@
+package pt
+
+// Tiles used if AdaptiveSampling.TileSize is not set
+const DEFAULT_TILE_SIZE = 8
+
+// Tiles of the image that are still sampled during adaptive sampling.
+// A nil mask samples every pixel
+type tileMask struct {
+	buff      varianceBuffer
+	threshold float64
+	size      int
+	columns   int
+	rows      int
+	active    []bool
+	remaining int // number of active tiles
+}
+
+// Returns the tile mask for rendering into buff, nil if adaptive sampling is disabled or buff keeps no variance
+func (r *ImageRenderer) newTiles(buff Buffer) *tileMask {
+	variance, ok := buff.(varianceBuffer)
+	if !ok || r.Adaptive.NoiseThreshold <= 0 {
+		return nil
+	}
+	size := r.Adaptive.TileSize
+	if size <= 0 {
+		size = DEFAULT_TILE_SIZE
+	}
+	t := &tileMask{
+		buff:      variance,
+		threshold: r.Adaptive.NoiseThreshold,
+		size:      size,
+		columns:   (buff.Width() + size - 1) / size,
+		rows:      (buff.Height() + size - 1) / size,
+	}
+	t.active = make([]bool, t.columns*t.rows)
+	for i := range t.active {
+		t.active[i] = true
+	}
+	t.remaining = len(t.active)
+	return t
+}
+
+// Whether pixel x,y is still sampled
+func (t *tileMask) sampled(x, y int) bool {
+	return t == nil || t.active[(y/t.size)*t.columns+x/t.size]
+}
+
+// Whether any pixel of row y is still sampled
+func (t *tileMask) rowSampled(y int) bool {
+	if t == nil {
+		return true
+	}
+	row := (y / t.size) * t.columns
+	for _, active := range t.active[row : row+t.columns] {
+		if active {
+			return true
+		}
+	}
+	return false
+}
+
+// Stops sampling tiles whose pixels all have a relative error below the threshold.
+// Must not be called while samples are added to the buffer
+func (t *tileMask) update() {
+	if t == nil {
+		return
+	}
+	width := t.buff.Width()
+	height := t.buff.Height()
+	for i, active := range t.active {
+		if !active {
+			continue
+		}
+		x0 := (i % t.columns) * t.size
+		y0 := (i / t.columns) * t.size
+		converged := true
+		for y := y0; y < y0+t.size && y < height && converged; y++ {
+			for x := x0; x < x0+t.size && x < width; x++ {
+				if t.buff.relativeError(x, y) >= t.threshold {
+					converged = false
+					break
+				}
+			}
+		}
+		if converged {
+			t.active[i] = false
+			t.remaining--
+		}
+	}
+}
+
+// Fraction of converged tiles
+func (t *tileMask) converged() float64 {
+	if t == nil {
+		return 0
+	}
+	return 1 - float64(t.remaining)/float64(len(t.active))
+}
+
+// Whether all tiles converged
+func (t *tileMask) done() bool {
+	return t != nil && t.remaining == 0
+}
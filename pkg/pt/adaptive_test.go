@@ -0,0 +1,141 @@
+package pt
+
+import (
+	gocontext "context"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTileMask(t *testing.T) {
+	buff := NewPxlBuffer(20, 10)
+	r := &ImageRenderer{Adaptive: AdaptiveSampling{NoiseThreshold: 0.05, TileSize: 8}}
+	tiles := r.newTiles(buff)
+	if tiles.columns != 3 || tiles.rows != 2 || tiles.remaining != 6 || tiles.converged() != 0 {
+		t.Fatalf("%vx%v tiles with %v remaining", tiles.columns, tiles.rows, tiles.remaining)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < buff.height; y++ {
+		for x := 0; x < buff.width; x++ {
+			for i := 0; i < 4; i++ {
+				c := NewColor(1, 1, 1)
+				// Noise in a single pixel of the last tile and the bottom right tile
+				if (x == 19 && y == 9) || (x == 16 && y == 0) {
+					c = c.Scale(rng.Float64() * 2)
+				}
+				buff.addSample(x, y, c)
+			}
+		}
+	}
+	tiles.update()
+	if tiles.remaining != 2 || math.Abs(tiles.converged()-4.0/6) > 1e-12 || tiles.done() {
+		t.Errorf("%v tiles remaining after the update, converged fraction %v", tiles.remaining, tiles.converged())
+	}
+	tests := []struct {
+		x, y    int
+		sampled bool
+	}{
+		{0, 0, false},
+		{7, 9, false},
+		{8, 3, false},
+		{16, 7, true},
+		{19, 8, true},
+		{17, 9, true},
+	}
+	for _, test := range tests {
+		if tiles.sampled(test.x, test.y) != test.sampled {
+			t.Errorf("pixel %v,%v sampled is %v", test.x, test.y, !test.sampled)
+		}
+	}
+	for y := 0; y < buff.height; y++ {
+		if !tiles.rowSampled(y) {
+			t.Errorf("row %v is not sampled", y)
+		}
+	}
+
+	for name, r := range map[string]*ImageRenderer{
+		"disabled":  {Adaptive: AdaptiveSampling{MinSpp: 4}},
+		"negative":  {Adaptive: AdaptiveSampling{NoiseThreshold: -1}},
+		"no buffer": {Adaptive: DefaultAdaptiveSampling()},
+	} {
+		var buff Buffer = NewPxlBuffer(4, 4)
+		if name == "no buffer" {
+			buff = NewFrameBuffer(4, 4)
+		}
+		tiles := r.newTiles(buff)
+		if tiles != nil || !tiles.sampled(1, 1) || !tiles.rowSampled(1) || tiles.converged() != 0 || tiles.done() {
+			t.Errorf("%v: adaptive sampling is not disabled", name)
+		}
+	}
+	if tiles := (&ImageRenderer{Adaptive: AdaptiveSampling{NoiseThreshold: 0.1}}).newTiles(buff); tiles.size != DEFAULT_TILE_SIZE {
+		t.Errorf("tile size without setting is %v", tiles.size)
+	}
+}
+
+func TestAdaptiveSampling(t *testing.T) {
+	// Black background and the inside of the light converge immediately, pixels covering its silhouette stay noisy
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Light{Color: NewColor(1, 1, 1)})))
+	cam := NewCamera(1, 40, CameraTransformation{NewVector3(0, 0, 5), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+	r := NewDefaultRenderer(scene.Compile(), cam)
+	r.Spp = 64
+	r.Adaptive = AdaptiveSampling{MinSpp: 4, NoiseThreshold: 0.01, TileSize: 4}
+	buff := NewPxlBuffer(32, 32)
+	converged := 0.0
+	passes := 0
+	err := r.RenderContext(gocontext.Background(), buff, RenderOptions{Progress: func(p Progress) {
+		if p.Converged < converged || (p.Pass < r.Adaptive.MinSpp-1 && p.Converged != 0) {
+			t.Errorf("pass %v: converged fraction %v after %v", p.Pass, p.Converged, converged)
+		}
+		converged = p.Converged
+		passes++
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converged <= 0 || converged >= 1 || passes != r.Spp {
+		t.Fatalf("converged fraction %v after %v passes", converged, passes)
+	}
+	tiles := r.newTiles(buff)
+	tiles.update()
+	minimal, maximal := 0, 0
+	for y := 0; y < buff.height; y++ {
+		for x := 0; x < buff.width; x++ {
+			samples := buff.buff[y*buff.width+x].samples
+			switch {
+			case samples < r.Adaptive.MinSpp || samples > r.Spp:
+				t.Fatalf("pixel %v,%v has %v samples", x, y, samples)
+			case samples == r.Adaptive.MinSpp:
+				minimal++
+				if tiles.sampled(x, y) {
+					t.Errorf("pixel %v,%v stopped sampling but is not converged", x, y)
+				}
+			case samples == r.Spp:
+				maximal++
+			}
+		}
+	}
+	if minimal == 0 || maximal == 0 {
+		t.Errorf("%v pixels stopped after the minimum samples and %v received all samples", minimal, maximal)
+	}
+}
+
+func TestAdaptiveSamplingConverges(t *testing.T) {
+	// A scene behind the camera converges after the minimum samples
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 10), 1)}, Light{Color: NewColor(1, 1, 1)})))
+	r := NewDefaultRenderer(scene.Compile(), NewCamera(1, 40, CameraTransformation{NewVector3(0, 0, 5), NewVector3(0, 0, 0), NewVector3(0, 1, 0)}))
+	r.Spp = 64
+	r.Adaptive = DefaultAdaptiveSampling()
+	buff := NewPxlBuffer(20, 12)
+	var last Progress
+	r.RenderContext(gocontext.Background(), buff, RenderOptions{Progress: func(p Progress) { last = p }})
+	if last.Pass != r.Adaptive.MinSpp-1 || last.Converged != 1 {
+		t.Errorf("rendering ended after pass %v with converged fraction %v", last.Pass, last.Converged)
+	}
+	for i := range buff.buff {
+		if buff.buff[i].samples != r.Adaptive.MinSpp {
+			t.Fatalf("pixel %v has %v samples", i, buff.buff[i].samples)
+		}
+	}
+}
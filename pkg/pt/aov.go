@@ -0,0 +1,319 @@
+package pt
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Arbitrary output variable recorded by an AOVBuffer besides the radiance
+type AOV int
+
+const (
+	AOVRadiance   AOV = iota
+	AOVDepth          // distance from the camera to the first hit, infinite if nothing was hit
+	AOVNormal         // world space shading normal at the first hit
+	AOVAlbedo         // surface color of the material at the first hit
+	AOVMaterialID     // see Mesh.MaterialID, 0 if nothing was hit
+	AOVObjectID       // see SceneNode.ObjectID, 0 if nothing was hit
+	AOVDirect         // light reaching the camera after at most one bounce
+	AOVIndirect       // light reaching the camera after more than one bounce
+	AOVVariance       // sample variance of the radiance
+)
+
+// All output variables in the order of their layers
+var AOVs = []AOV{AOVRadiance, AOVDepth, AOVNormal, AOVAlbedo, AOVMaterialID, AOVObjectID, AOVDirect, AOVIndirect, AOVVariance}
+
+// Name of the layer of the output variable
+func (a AOV) String() string {
+	switch a {
+	case AOVRadiance:
+		return "radiance"
+	case AOVDepth:
+		return "depth"
+	case AOVNormal:
+		return "normal"
+	case AOVAlbedo:
+		return "albedo"
+	case AOVMaterialID:
+		return "materialID"
+	case AOVObjectID:
+		return "objectID"
+	case AOVDirect:
+		return "direct"
+	case AOVIndirect:
+		return "indirect"
+	case AOVVariance:
+		return "variance"
+	}
+	return "unknown"
+}
+
+// Output variables of a single camera sample, filled while tracing the sample
+type aovSample struct {
+	hit        bool
+	depth      float64
+	normal     Vector3
+	albedo     Color
+	materialID int
+	objectID   int
+	direct     Color
+	throughput Color // attenuation of the first bounce, applied to direct light recorded after it
+}
+
+func newAOVSample() aovSample {
+	return aovSample{throughput: NewColor(1, 1, 1)}
+}
+
+// Records the first hit of the camera ray r
+func (s *aovSample) recordHit(r ray, h *hit) {
+	s.hit = true
+	s.depth = h.t * r.direction.Length()
+	s.normal = h.normal
+	if m, ok := h.material.(albedoMaterial); ok {
+		s.albedo = m.surfaceAlbedo(h)
+	}
+	if h.node != nil {
+		s.objectID = h.node.id
+		if h.node.mesh != nil {
+			s.materialID = h.node.mesh.materialID
+		}
+	}
+}
+
+// Materials with a surface color, which is recorded as albedo
+type albedoMaterial interface {
+	surfaceAlbedo(h *hit) Color
+}
+
+// Adds light reaching the camera after at most one bounce to the direct light of the sample.
+// Shaders call it with emitted light before incrementing the depth and with sampled light after
+func (c context) addDirect(light Color) {
+	if c.aov != nil && c.depth <= 1 {
+		c.aov.direct = c.aov.direct.Add(light.Blend(c.aov.throughput))
+	}
+}
+
+// Called by shaders with the attenuation of the scattered ray before it is traced
+func (c context) scattered(attenuation Color) {
+	if c.aov != nil && c.depth == 1 {
+		c.aov.throughput = attenuation
+	}
+}
+
+type aovPixel struct {
+	Pixel      // radiance
+	hits       int
+	direct     Color
+	depth      float64
+	normal     Vector3
+	albedo     Color
+	materialID int // of the first sample
+	objectID   int // of the first sample
+}
+
+func (px *aovPixel) addAOVSample(c Color, s *aovSample) {
+	px.addSample(c)
+	n := float64(px.samples)
+	px.direct = px.direct.Add(s.direct.Sub(px.direct).Div(n))
+	px.normal = px.normal.Add(s.normal.Sub(px.normal).Mul(1 / n))
+	px.albedo = px.albedo.Add(s.albedo.Sub(px.albedo).Div(n))
+	if px.samples == 1 {
+		px.materialID = s.materialID
+		px.objectID = s.objectID
+	}
+	if s.hit {
+		// Depth is averaged over the samples that hit
+		px.hits++
+		px.depth += (s.depth - px.depth) / float64(px.hits)
+	}
+}
+
+func (px *aovPixel) value(aov AOV) Color {
+	switch aov {
+	case AOVRadiance:
+		return px.color
+	case AOVDepth:
+		if px.hits == 0 {
+			return NewColor(math.Inf(1), math.Inf(1), math.Inf(1))
+		}
+		return NewColor(px.depth, px.depth, px.depth)
+	case AOVNormal:
+		return Color(px.normal)
+	case AOVAlbedo:
+		return px.albedo
+	case AOVMaterialID:
+		id := float64(px.materialID)
+		return NewColor(id, id, id)
+	case AOVObjectID:
+		id := float64(px.objectID)
+		return NewColor(id, id, id)
+	case AOVDirect:
+		return px.direct
+	case AOVIndirect:
+		return px.color.Sub(px.direct)
+	case AOVVariance:
+		return px.variance()
+	}
+	return NewColor(0, 0, 0)
+}
+
+// Buffer recording the output variables of every sample besides its radiance.
+// Rendering into it with ImageRenderer.RenderContext fills all layers, direct light is only separated by the shaders of this package
+type AOVBuffer struct {
+	// Applied when converting the radiance to 8 bit colors
+	PostProcess PostProcess
+	width       int
+	height      int
+	buff        []aovPixel
+}
+
+func NewAOVBuffer(width, height int) *AOVBuffer {
+	return &AOVBuffer{
+		PostProcess: DefaultPostProcess(),
+		width:       width,
+		height:      height,
+		buff:        make([]aovPixel, width*height),
+	}
+}
+
+// Adds a sample without output variables, like a camera ray that could not be cast
+func (b *AOVBuffer) addSample(x, y int, c Color) {
+	s := newAOVSample()
+	b.addAOVSample(x, y, c, &s)
+}
+
+func (b *AOVBuffer) addAOVSample(x, y int, c Color, s *aovSample) {
+	b.buff[y*b.width+x].addAOVSample(c, s)
+}
+
+// Resets all pixels, e.g. to render the next frame into the buffer
+func (b *AOVBuffer) Clear() {
+	for i := range b.buff {
+		b.buff[i] = aovPixel{}
+	}
+}
+
+func (b *AOVBuffer) relativeError(x, y int) float64 {
+	return b.buff[y*b.width+x].relativeError()
+}
+
+func (b *AOVBuffer) Width() int {
+	return b.width
+}
+
+func (b *AOVBuffer) Height() int {
+	return b.height
+}
+
+// Returns the values of a single output variable, e.g. to write it with PixelBuffer.WritePFM.
+// Scalar variables are stored in all three channels
+func (b *AOVBuffer) Layer(aov AOV) *PixelBuffer {
+	layer := NewPxlBuffer(b.width, b.height)
+	if aov == AOVRadiance {
+		layer.PostProcess = b.PostProcess
+	}
+	for i := range b.buff {
+		layer.buff[i] = Pixel{
+			samples: b.buff[i].samples,
+			color:   b.buff[i].value(aov),
+		}
+		if aov == AOVRadiance {
+			layer.buff[i].m2 = b.buff[i].m2
+		}
+	}
+	return layer
+}
+
+// Returns a displayable image of the output variable. Depth is normalized to the farthest hit,
+// normals are mapped from [-1,1] to [0,1] and every ID gets its own color
+func (b *AOVBuffer) LayerImage(aov AOV) image.Image {
+	maxDepth := 0.0
+	if aov == AOVDepth {
+		for i := range b.buff {
+			if b.buff[i].hits > 0 {
+				maxDepth = math.Max(maxDepth, b.buff[i].depth)
+			}
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, b.width, b.height))
+	for i := range b.buff {
+		x := i % b.width
+		y := i / b.width
+		px := &b.buff[i]
+		var c color.RGBA
+		switch aov {
+		case AOVRadiance, AOVDirect, AOVIndirect, AOVVariance:
+			c = b.PostProcess.apply(px.value(aov), x, y)
+		case AOVDepth:
+			d := 0.0
+			if px.hits > 0 && maxDepth > 0 {
+				d = 1 - px.depth/maxDepth
+			}
+			v := uint8(255 * d)
+			c = color.RGBA{v, v, v, 255}
+		case AOVNormal:
+			n := px.normal.Mul(0.5).Add(NewVector3(0.5, 0.5, 0.5))
+			c = color.RGBA{quantize(n.X, 0), quantize(n.Y, 0), quantize(n.Z, 0), 255}
+		case AOVAlbedo:
+			c = DefaultPostProcess().apply(px.albedo, x, y)
+		case AOVMaterialID, AOVObjectID:
+			c = idColor(int(px.value(aov).X))
+		}
+		img.Set(x, b.height-1-y, c)
+	}
+	return img
+}
+
+// Pseudo random color of an ID, black for 0
+func idColor(id int) color.RGBA {
+	if id == 0 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	h := hash32(uint32(id))
+	return color.RGBA{uint8(h), uint8(h >> 8), uint8(h >> 16), 255}
+}
+
+// Writes the output variables as layers of a scanline OpenEXR image, all of them if none are given.
+// The radiance is stored in R, G and B, depth in Z and the other variables in channels prefixed by their name, e.g. normal.X
+func (b *AOVBuffer) WriteEXR(w io.Writer, compression EXRCompression, aovs ...AOV) error {
+	if len(aovs) == 0 {
+		aovs = AOVs
+	}
+	var channels []exrChannel
+	for _, aov := range aovs {
+		var names []string
+		switch aov {
+		case AOVRadiance:
+			names = []string{"R", "G", "B"}
+		case AOVDepth:
+			names = []string{"Z"}
+		case AOVNormal:
+			names = []string{"normal.X", "normal.Y", "normal.Z"}
+		case AOVMaterialID, AOVObjectID:
+			names = []string{aov.String() + ".id"}
+		default:
+			names = []string{aov.String() + ".R", aov.String() + ".G", aov.String() + ".B"}
+		}
+		values := make([][]float32, len(names))
+		for i := range values {
+			values[i] = make([]float32, len(b.buff))
+		}
+		for y := 0; y < b.height; y++ {
+			// EXR rows are stored top to bottom
+			row := (b.height - 1 - y) * b.width
+			for x := 0; x < b.width; x++ {
+				c := b.buff[y*b.width+x].value(aov)
+				components := [3]float64{c.X, c.Y, c.Z}
+				for i := range values {
+					values[i][row+x] = float32(components[i])
+				}
+			}
+		}
+		for i, name := range names {
+			channels = append(channels, exrChannel{name: name, values: values[i]})
+		}
+	}
+	return writeEXR(w, b.width, b.height, channels, compression)
+}
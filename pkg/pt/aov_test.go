@@ -0,0 +1,141 @@
+package pt
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+)
+
+type sliceMaterial struct {
+	Diffuse
+	Layers []Color // not comparable, so the material can not be used as a map key
+}
+
+func TestMaterialAndObjectIDs(t *testing.T) {
+	shared := &Diffuse{Albedo: NewColor(1, 0, 0)}
+	sharedMesh := NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Diffuse{})
+	meshes := []*Mesh{
+		NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, shared),
+		NewMesh(Geometry{NewSphere(NewVector3(3, 0, 0), 1)}, shared),
+		NewMesh(Geometry{NewSphere(NewVector3(6, 0, 0), 1)}, Diffuse{}),
+		NewMesh(Geometry{NewSphere(NewVector3(9, 0, 0), 1)}, Diffuse{}),
+		NewMesh(Geometry{NewSphere(NewVector3(12, 0, 0), 1)}, sliceMaterial{Layers: []Color{{}}}),
+		NewMesh(Geometry{NewSphere(NewVector3(15, 0, 0), 1)}, nil),
+		sharedMesh,
+	}
+	scene := NewScene()
+	var nodes []*SceneNode
+	for _, m := range meshes {
+		node := NewSceneNode(m)
+		nodes = append(nodes, node)
+		scene.Add(node)
+	}
+	// A second node referencing the same mesh, nested below an empty node
+	group := NewSceneNode(nil)
+	instance := NewSceneNode(sharedMesh)
+	group.Add(instance)
+	scene.Add(group)
+	nodes = append(nodes, instance)
+	scene.Compile()
+
+	if meshes[0].MaterialID() != meshes[1].MaterialID() {
+		t.Errorf("meshes sharing a material pointer got IDs %v and %v", meshes[0].MaterialID(), meshes[1].MaterialID())
+	}
+	ids := map[int]bool{}
+	for _, m := range meshes[1:] {
+		if m.MaterialID() < 1 || ids[m.MaterialID()] {
+			t.Errorf("material ID %v is not unique", m.MaterialID())
+		}
+		ids[m.MaterialID()] = true
+	}
+	objects := []int{}
+	for _, n := range nodes {
+		objects = append(objects, n.ObjectID())
+	}
+	sort.Ints(objects)
+	for i, id := range objects {
+		if id != i+1 {
+			t.Fatalf("object IDs are %v, expected 1 to %v", objects, len(nodes))
+		}
+	}
+	if group.ObjectID() != 0 {
+		t.Errorf("node without mesh got object ID %v", group.ObjectID())
+	}
+}
+
+func TestAOVBuffer(t *testing.T) {
+	albedo := NewColor(.2, .4, .6)
+	sphere := NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, &Diffuse{Albedo: albedo}))
+	scene := NewScene()
+	scene.Add(sphere)
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 4, 0), 1)}, Light{Color: NewColor(8, 8, 8)})))
+	cam := NewCamera(1, 40, CameraTransformation{NewVector3(0, 0, 5), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+	r := NewDefaultRenderer(scene.Compile(), cam)
+	r.Spp = 4
+	const size = 33
+	buff := NewAOVBuffer(size, size)
+	r.RenderToBuffer(buff)
+
+	center := size/2*size + size/2
+	layer := func(aov AOV, i int) Color { return buff.Layer(aov).buff[i].color }
+	if depth := layer(AOVDepth, center).X; math.Abs(depth-4) > 0.05 {
+		t.Errorf("depth at the center is %v, expected 4", depth)
+	}
+	if normal := Vector3(layer(AOVNormal, center)); normal.Dot(NewVector3(0, 0, 1)) < 0.99 {
+		t.Errorf("normal at the center is %v", normal)
+	}
+	if got := layer(AOVAlbedo, center); Vector3(got).Sub(Vector3(albedo)).Length() > 1e-9 {
+		t.Errorf("albedo at the center is %v, expected %v", got, albedo)
+	}
+	if got := layer(AOVObjectID, center).X; got != float64(sphere.ObjectID()) {
+		t.Errorf("object ID at the center is %v, expected %v", got, sphere.ObjectID())
+	}
+	if got := layer(AOVMaterialID, center).X; got != float64(sphere.mesh.MaterialID()) {
+		t.Errorf("material ID at the center is %v, expected %v", got, sphere.mesh.MaterialID())
+	}
+	// The bottom corner sees neither the sphere nor the light
+	if !math.IsInf(layer(AOVDepth, 0).X, 1) || layer(AOVObjectID, 0).X != 0 || layer(AOVMaterialID, 0).X != 0 {
+		t.Errorf("missed pixel has depth %v and IDs %v, %v", layer(AOVDepth, 0).X, layer(AOVObjectID, 0).X, layer(AOVMaterialID, 0).X)
+	}
+	for i := range buff.buff {
+		sum := layer(AOVDirect, i).Add(layer(AOVIndirect, i))
+		if Vector3(sum).Sub(Vector3(layer(AOVRadiance, i))).Length() > 1e-9 {
+			t.Fatalf("direct and indirect light of pixel %v add up to %v, expected %v", i, sum, layer(AOVRadiance, i))
+		}
+	}
+
+	encoded := &bytes.Buffer{}
+	if err := buff.WriteEXR(encoded, EXRZIPCompression, AOVRadiance, AOVDepth, AOVNormal, AOVObjectID); err != nil {
+		t.Fatal(err)
+	}
+	channels := readTestEXR(t, encoded.Bytes(), size, size)
+	for _, name := range []string{"R", "G", "B", "Z", "normal.X", "normal.Y", "normal.Z", "objectID.id"} {
+		if _, ok := channels[name]; !ok {
+			t.Errorf("channel %v is missing", name)
+		}
+	}
+	// EXR rows are stored top to bottom, the center row is the same
+	if got := channels["objectID.id"][center]; got != float32(sphere.ObjectID()) {
+		t.Errorf("object ID channel at the center is %v", got)
+	}
+}
+
+func TestAOVDirectLight(t *testing.T) {
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Light{Color: NewColor(2, 3, 4)})))
+	cam := NewCamera(1, 40, CameraTransformation{NewVector3(0, 0, 5), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+	for name, shader := range map[string]ClosestHitShader{"default": DefaultClosestHitShader, "light sampling": LightSamplingShader} {
+		r := NewDefaultRenderer(scene.Compile(), cam)
+		r.Closest = shader
+		r.Spp = 4
+		buff := NewAOVBuffer(9, 9)
+		r.RenderToBuffer(buff)
+		// Light seen directly by the camera is all direct light
+		for i := range buff.buff {
+			if indirect := buff.buff[i].value(AOVIndirect); Vector3(indirect).Length() > 1e-9 {
+				t.Fatalf("%v: pixel %v has indirect light %v", name, i, indirect)
+			}
+		}
+	}
+}
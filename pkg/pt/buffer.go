@@ -3,6 +3,7 @@ package pt
 import (
 	"image"
 	"image/color"
+	"math"
 )
 
 type Buffer interface {
@@ -11,24 +12,51 @@ type Buffer interface {
 	Height() int
 }
 
+// Buffers keeping the variance of their pixels, required for adaptive sampling
+type varianceBuffer interface {
+	Buffer
+	relativeError(x, y int) float64
+}
+
+// Added to the mean of dark pixels when estimating their relative error
+const RELATIVE_ERROR_EPSILON = 0.01
+
 type Pixel struct {
 	samples int
 	color   Color
+	m2      Color // sum of squared differences of the samples from their mean
 }
 
 func (px *Pixel) addSample(c Color) {
 	px.samples++
-	if px.samples == 1 {
-		px.color = c
-		return
+	// Welford's online algorithm for the mean and variance
+	delta := c.Sub(px.color)
+	px.color = px.color.Add(delta.Div(float64(px.samples)))
+	px.m2 = px.m2.Add(delta.Blend(c.Sub(px.color)))
+}
+
+// Sample variance per color channel
+func (px *Pixel) variance() Color {
+	if px.samples < 2 {
+		return NewColor(0, 0, 0)
+	}
+	return px.m2.Div(float64(px.samples - 1))
+}
+
+// Estimated standard error of the mean luminance relative to the mean luminance
+func (px *Pixel) relativeError() float64 {
+	if px.samples < 2 {
+		return math.Inf(1)
 	}
-	px.color = px.color.Add(c.Sub(px.color).Div(float64(px.samples)))
+	return math.Sqrt(luminance(px.variance())/float64(px.samples)) / (luminance(px.color) + RELATIVE_ERROR_EPSILON)
 }
 
 type PixelBuffer struct {
-	width  int
-	height int
-	buff   []Pixel
+	// Applied when converting to 8 bit colors
+	PostProcess PostProcess
+	width       int
+	height      int
+	buff        []Pixel
 }
 
 func NewPxlBufferAR(height int, aspect float64) *PixelBuffer {
@@ -38,9 +66,10 @@ func NewPxlBufferAR(height int, aspect float64) *PixelBuffer {
 
 func NewPxlBuffer(width, height int) *PixelBuffer {
 	return &PixelBuffer{
-		width:  width,
-		height: height,
-		buff:   make([]Pixel, width*height),
+		PostProcess: DefaultPostProcess(),
+		width:       width,
+		height:      height,
+		buff:        make([]Pixel, width*height),
 	}
 }
 
@@ -48,6 +77,18 @@ func (b *PixelBuffer) addSample(x, y int, c Color) {
 	b.buff[y*b.width+x].addSample(c)
 }
 
+func (b *PixelBuffer) relativeError(x, y int) float64 {
+	return b.buff[y*b.width+x].relativeError()
+}
+
+// Returns a copy of the buffer, e.g. to preview a running render from RenderOptions.Progress
+func (b *PixelBuffer) Snapshot() *PixelBuffer {
+	snapshot := NewPxlBuffer(b.width, b.height)
+	snapshot.PostProcess = b.PostProcess
+	copy(snapshot.buff, b.buff)
+	return snapshot
+}
+
 func (b *PixelBuffer) Height() int {
 	return b.height
 }
@@ -62,16 +103,18 @@ func (b *PixelBuffer) ToImage() image.Image {
 	img := image.NewRGBA(image.Rectangle{topLeft, bottomRight})
 	for i, px := range b.buff {
 		x := i % b.width
-		y := b.height - (i / b.width)
-		img.Set(x, y, px.color.goColor())
+		y := i / b.width
+		img.Set(x, b.height-1-y, b.PostProcess.apply(px.color, x, y))
 	}
 	return img
 }
 
 type FrameBuffer struct {
-	width  int
-	height int
-	buff   []Color
+	// Applied when converting to 8 bit colors
+	PostProcess PostProcess
+	width       int
+	height      int
+	buff        []Color
 }
 
 func NewFrameBufferAR(height int, aspect float64) *FrameBuffer {
@@ -81,9 +124,10 @@ func NewFrameBufferAR(height int, aspect float64) *FrameBuffer {
 
 func NewFrameBuffer(width, height int) *FrameBuffer {
 	return &FrameBuffer{
-		width:  width,
-		height: height,
-		buff:   make([]Color, width*height),
+		PostProcess: DefaultPostProcess(),
+		width:       width,
+		height:      height,
+		buff:        make([]Color, width*height),
 	}
 }
 
@@ -100,7 +144,7 @@ func (b *FrameBuffer) Height() int {
 }
 
 func (b *FrameBuffer) GoColor(index int) color.Color {
-	return b.buff[index].goColor()
+	return b.PostProcess.apply(b.buff[index], index%b.width, index/b.width)
 }
 
 func (b *FrameBuffer) ToImage() image.Image {
@@ -109,8 +153,8 @@ func (b *FrameBuffer) ToImage() image.Image {
 	img := image.NewRGBA(image.Rectangle{topLeft, bottomRight})
 	for i, color := range b.buff {
 		x := i % b.width
-		y := b.height - (i / b.width)
-		img.Set(x, y, color.goColor())
+		y := i / b.width
+		img.Set(x, b.height-1-y, b.PostProcess.apply(color, x, y))
 	}
 	return img
 }
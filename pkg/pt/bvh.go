@@ -11,10 +11,17 @@ const (
 	TRAVERSAL_COST    = 2.0 // cost of traversal relative to intersection cost
 )
 
+// Hits closer to the origin are ignored by Occluded to avoid self intersections
+const OCCLUSION_EPSILON = 0.0001
+
 type BVH struct {
-	root   *bvhNode
-	prims  []tracable
-	leaves []*bvhNode
+	root      *bvhNode
+	prims     []tracable
+	leaves    []*bvhNode
+	lights    []tracable // emissive tracables that can be sampled directly
+	instanced bool       // meshes are referenced through instances of bottom level BVHs
+	builtCost float64    // cost right after building, used to decide when refitting is no longer sufficient
+	wide      *wideBVH   // flattened layout used for intersection tests if set
 }
 
 // Number of intersection tests executed for given ray, including node bounding boxes and leaf primitives
@@ -72,10 +79,26 @@ func (bvh *BVH) Print() {
 }
 
 func (bvh *BVH) intersected(ray ray, tMin, tMax float64, hitOut *hit) bool {
+	if bvh.wide != nil {
+		return bvh.wide.intersected(ray, tMin, tMax, hitOut)
+	}
 	hitOut.t = tMax
 	return bvh.root.intersected(bvh.prims, ray, tMin, tMax, hitOut)
 }
 
+// Reports whether anything is hit between origin and tMax along direction, stops at the first hit found.
+// Cheaper than a closest hit query, used for shadow rays and visibility tests
+func (bvh *BVH) Occluded(origin, direction Vector3, tMax float64) bool {
+	return bvh.occluded(newRay(origin, direction), OCCLUSION_EPSILON, tMax)
+}
+
+func (bvh *BVH) occluded(ray ray, tMin, tMax float64) bool {
+	if bvh.wide != nil {
+		return bvh.wide.occluded(ray, tMin, tMax)
+	}
+	return bvh.root.occluded(bvh.prims, ray, tMin, tMax)
+}
+
 func (bvh *BVH) intersectedStack(ray ray, tMin, tMax float64, hitOut *hit) bool {
 	stack := bvhStack{}
 	stack.push(bvh.root)
@@ -101,6 +124,36 @@ func (bvh *BVH) intersectedStack(ray ray, tMin, tMax float64, hitOut *hit) bool
 	}
 }
 
+// Stores all tracables with an emissive material and a samplable primitive, which are used for next event estimation
+func (bvh *BVH) collectLights() {
+	bvh.lights = make([]tracable, 0)
+	for i := range bvh.prims {
+		prim := &bvh.prims[i]
+		_, ok := prim.prim.(samplable)
+		prim.light = ok && prim.mat != nil && !prim.mat.emittedLight().isBlack()
+		if prim.light {
+			bvh.lights = append(bvh.lights, *prim)
+		}
+	}
+}
+
+// Samples a point on the light at index as seen from origin using the uniform numbers u and v.
+// Returns the light, the sampled point and the pdf with respect to solid angle including the uniform choice of the light
+func (bvh *BVH) sampleLight(origin Vector3, index int, u, v float64) (tracable, Vector3, float64) {
+	light := bvh.lights[index]
+	point, _, pdf := light.prim.(samplable).sample(origin, u, v)
+	return light, point, pdf / float64(len(bvh.lights))
+}
+
+// Pdf with respect to solid angle of sampling the emitter at h from origin through sampleLight,
+// 0 for emitters that are not in the light list, like moving or instanced meshes
+func (bvh *BVH) lightPdf(origin Vector3, h *hit) float64 {
+	if !h.light {
+		return 0
+	}
+	return h.prim.(samplable).pdf(origin, h.point) / float64(len(bvh.lights))
+}
+
 func (bvh *BVH) updateBounding(threads int) {
 	wg := sync.WaitGroup{}
 	wg.Add(threads)
@@ -120,11 +173,27 @@ func (bvh *BVH) updateBounding(threads int) {
 	wg.Wait()
 }
 
+// Returns a copy with its own primitives and nodes, which can be refitted without changing bvh.
+// Leaves have to be stored again, the flattened layout and bottom level BVHs are shared until they are replaced
+func (bvh BVH) clone() BVH {
+	bvh.prims = append([]tracable(nil), bvh.prims...)
+	bvh.root = bvh.root.clone(nil)
+	bvh.leaves = nil
+	return bvh
+}
+
 func (bvh *BVH) storeLeaves() {
 	bvh.leaves = make([]*bvhNode, 0)
 	bvh.root.collectLeaves(&bvh.leaves)
 }
 
+// BVH without primitives. Its root is an empty leaf, so that traversals need no special case
+func emptyBVH() BVH {
+	root := newLeaf(nil)
+	root.bounding = newAABB(Vector3{}, Vector3{})
+	return BVH{root: root}
+}
+
 type bvhNode struct {
 	parent       *bvhNode
 	prims        []int
@@ -151,6 +220,22 @@ func newBranch(children int) *bvhNode {
 	}
 }
 
+// Copies the subtree below node, the primitive indices of leaves are shared
+func (node *bvhNode) clone(parent *bvhNode) *bvhNode {
+	if node == nil {
+		return nil
+	}
+	copied := *node
+	copied.parent = parent
+	if !node.isLeaf {
+		copied.children = make([]*bvhNode, len(node.children))
+		for i, child := range node.children {
+			copied.children[i] = child.clone(&copied)
+		}
+	}
+	return &copied
+}
+
 func (node *bvhNode) addChild(child *bvhNode, index int) {
 	node.children[index] = child
 	child.parent = node
@@ -198,10 +283,33 @@ func (node *bvhNode) intersected(prims []tracable, ray ray, tMin, tMax float64,
 	return didHit
 }
 
+func (node *bvhNode) occluded(prims []tracable, ray ray, tMin, tMax float64) bool {
+	if !node.bounding.intersected(ray, tMin, tMax) {
+		return false
+	}
+	if node.isLeaf {
+		for i := 0; i < len(node.prims); i++ {
+			if prims[node.prims[i]].prim.occluded(ray, tMin, tMax) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, child := range node.children {
+		if child.occluded(prims, ray, tMin, tMax) {
+			return true
+		}
+	}
+	return false
+}
+
 func (node *bvhNode) updateAABB(primitives []tracable) {
-	// This can cause an error, if a BVH with only one primitive is called. I assumed that would not be the case, but it is possible.
 	if node.isLeaf {
 		node.bounding = enclosingSlice(node.prims, primitives)
+		// A BVH with a single leaf has no parent to propagate to
+		if node.parent == nil {
+			return
+		}
 		// Atomic counter. after all child bounding boxes have been computed the parents bounding box can be calculated
 		if atomic.AddUint32(&node.parent.childAABBset, 1)%uint32(len(node.parent.children)) == 0 {
 			node.parent.updateAABB(primitives)
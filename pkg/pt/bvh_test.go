@@ -0,0 +1,130 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Scene of randomly placed triangle and sphere meshes, each mesh is used by several nodes.
+// The nodes are only moved and rotated, transformed spheres keep their radius
+func bvhTestScene(r *rand.Rand) *Scene {
+	scene := NewScene()
+	randomPoint := func(size float64) Vector3 {
+		return NewVector3(r.Float64()*size-size/2, r.Float64()*size-size/2, r.Float64()*size-size/2)
+	}
+	for m := 0; m < 4; m++ {
+		geometry := Geometry{}
+		for i := 0; i < 50; i++ {
+			center := randomPoint(4)
+			if i%5 == 0 {
+				geometry = append(geometry, NewSphere(center, 0.1+r.Float64()*0.3))
+			} else {
+				geometry = append(geometry, NewTriangleWithoutNormals(center, center.Add(randomPoint(1)), center.Add(randomPoint(1))))
+			}
+		}
+		mesh := NewMesh(geometry, &Diffuse{Albedo: NewColor(r.Float64(), r.Float64(), r.Float64())})
+		for n := 0; n < 3; n++ {
+			node := NewSceneNode(mesh)
+			node.Translate(r.Float64()*20-10, r.Float64()*20-10, r.Float64()*20-10)
+			node.Rotate(NewVector3(r.Float64(), r.Float64(), r.Float64()), r.Float64()*math.Pi)
+			scene.Add(node)
+		}
+	}
+	return scene
+}
+
+// Rays from outside and inside the test scene
+func bvhTestRays(r *rand.Rand, n int) []ray {
+	rays := make([]ray, n)
+	for i := range rays {
+		origin := NewVector3(r.Float64()*30-15, r.Float64()*30-15, r.Float64()*30-15)
+		target := NewVector3(r.Float64()*20-10, r.Float64()*20-10, r.Float64()*20-10)
+		rays[i] = newRay(origin, target.Sub(origin))
+	}
+	return rays
+}
+
+// Fails if bvh and reference disagree on the closest hit or occlusion of any ray
+func expectSameHits(t *testing.T, name string, reference, bvh *BVH, rays []ray) {
+	t.Helper()
+	hits := 0
+	for i, ray := range rays {
+		expected, got := hit{}, hit{}
+		expectedOk := reference.intersected(ray, 0.0001, math.Inf(1), &expected)
+		ok := bvh.intersected(ray, 0.0001, math.Inf(1), &got)
+		if ok != expectedOk || math.Abs(got.t-expected.t) > 1e-9*math.Max(1, expected.t) || got.node != expected.node || got.index != expected.index {
+			t.Fatalf("%v: ray %v hit %v at %v, expected %v at %v", name, i, ok, got.t, expectedOk, expected.t)
+		}
+		if bvh.occluded(ray, 0.0001, math.Inf(1)) != expectedOk {
+			t.Fatalf("%v: occlusion of ray %v differs from its closest hit", name, i)
+		}
+		if ok {
+			hits++
+		}
+	}
+	if hits == 0 || hits == len(rays) {
+		t.Fatalf("%v: %v of %v rays hit, the test rays do not tell hits and misses apart", name, hits, len(rays))
+	}
+}
+
+func TestBVHMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	scene := bvhTestScene(r)
+	bvh := scene.Compile()
+	prims := scene.collectTracables(false)
+	for i, ray := range bvhTestRays(r, 2000) {
+		expected := hit{t: math.Inf(1)}
+		expectedOk := false
+		for _, prim := range prims {
+			if prim.intersected(ray, 0.0001, expected.t, &expected) {
+				expectedOk = true
+			}
+		}
+		got := hit{}
+		ok := bvh.intersected(ray, 0.0001, math.Inf(1), &got)
+		if ok != expectedOk || (ok && (got.t != expected.t || got.node != expected.node || got.index != expected.index)) {
+			t.Fatalf("ray %v hit %v at %v, expected %v at %v", i, ok, got.t, expectedOk, expected.t)
+		}
+	}
+}
+
+func TestOccluded(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	scene := bvhTestScene(r)
+	moving := NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 2), NewSphere(NewVector3(3, 0, 0), 1)}, Diffuse{}))
+	moving.SetMotion(Translate(0, 4, 0))
+	scene.Add(moving)
+	rays := bvhTestRays(r, 2000)
+	for name, bvh := range map[string]BVH{"default": scene.Compile(), "instanced": scene.CompileInstanced()} {
+		for i, ray := range rays {
+			ray.time = r.Float64()
+			closest := hit{}
+			if !bvh.intersected(ray, OCCLUSION_EPSILON, math.Inf(1), &closest) {
+				closest.t = math.Inf(1)
+			}
+			// Occlusion is only reported for hits closer than tMax
+			for _, tMax := range []float64{closest.t * 0.99, closest.t * 1.01, math.Inf(1)} {
+				if got := bvh.occluded(ray, OCCLUSION_EPSILON, tMax); got != (closest.t < tMax) {
+					t.Fatalf("%v: ray %v with closest hit at %v occluded up to %v is %v", name, i, closest.t, tMax, got)
+				}
+			}
+		}
+	}
+}
+
+func TestOccludedDoesNotAllocate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	scene := bvhTestScene(r)
+	rays := bvhTestRays(r, 100)
+	for name, bvh := range map[string]BVH{"default": scene.Compile(), "instanced": scene.CompileInstanced()} {
+		allocs := testing.AllocsPerRun(10, func() {
+			for _, ray := range rays {
+				bvh.occluded(ray, OCCLUSION_EPSILON, math.Inf(1))
+			}
+		})
+		if allocs > 0 {
+			t.Errorf("%v: %v allocations per batch of occlusion tests", name, allocs)
+		}
+	}
+}
@@ -31,8 +31,22 @@ func newOrientation(t CameraTransformation) orientation {
 	}
 }
 
+// Thin lens model of the camera. A zero aperture results in a pinhole camera with everything in focus
+type Lens struct {
+	Aperture      float64 // radius of the lens
+	FocusDistance float64 // distance of the plane in focus along the view direction, 1 if 0
+	Blades        int     // number of aperture blades forming a regular polygon, a circular aperture if below 3
+	BladeRotation float64 // rotation of the polygonal aperture in radians
+}
+
 type Camera struct {
 	orientation orientation
+	lens        Lens
+	projection  Projection // perspective projection of the viewport if nil
+
+	// Interval of the ray times, see SceneNode.SetMotion
+	shutterOpen  float64
+	shutterClose float64
 
 	viewportWidth  float64
 	viewportHeight float64
@@ -58,25 +72,72 @@ func NewCamera(aspectRatio float64, fov float64, transform CameraTransformation)
 	return cam
 }
 
+func NewThinLensCamera(aspectRatio float64, fov float64, transform CameraTransformation, lens Lens) *Camera {
+	cam := NewCamera(aspectRatio, fov, transform)
+	cam.SetLens(lens)
+	return cam
+}
+
 func (c *Camera) SetTransformation(transformation CameraTransformation) {
 	c.orientation = newOrientation(transformation)
-	c.horizontal = c.orientation.u.Mul(c.viewportWidth)
-	c.vertical = c.orientation.v.Mul(c.viewportHeight)
-	c.lowerLeftCorner = c.orientation.origin.Sub(c.horizontal.Mul(0.5)).Sub(c.vertical.Mul(0.5)).Sub(c.orientation.w)
+	c.updateViewport()
+}
+
+func (c *Camera) SetLens(lens Lens) {
+	c.lens = lens
+	c.updateViewport()
+}
+
+func (c *Camera) Lens() Lens {
+	return c.lens
+}
+
+// Rays are cast at random times between open and close, where 0 is the start and 1 the end of the motion of nodes.
+// Both 0 disables motion blur
+func (c *Camera) SetShutter(open, close float64) {
+	c.shutterOpen = open
+	c.shutterClose = close
+}
+
+// Replaces the perspective projection of the camera, nil restores it. The lens only affects the perspective projection
+func (c *Camera) SetProjection(projection Projection) {
+	c.projection = projection
 }
 
 func (c *Camera) Translate(v Vector3) {
 	c.orientation.origin = c.orientation.origin.Add(v)
-	c.lowerLeftCorner = c.orientation.origin.Sub(c.horizontal.Mul(0.5)).Sub(c.vertical.Mul(0.5)).Sub(c.orientation.w)
+	c.updateViewport()
 }
 
 func (c *Camera) SetFront(v Vector3) {
 	c.orientation.w = v.Unit()
 	c.orientation.u = c.orientation.up.Cross(c.orientation.w).Unit()
 	c.orientation.v = c.orientation.w.Cross(c.orientation.u)
-	c.horizontal = c.orientation.u.Mul(c.viewportWidth)
-	c.vertical = c.orientation.v.Mul(c.viewportHeight)
-	c.lowerLeftCorner = c.orientation.origin.Sub(c.horizontal.Mul(0.5)).Sub(c.vertical.Mul(0.5)).Sub(c.orientation.w)
+	c.updateViewport()
+}
+
+// Sets the focus distance to the closest hit through the center of the image, returns false if nothing is hit
+func (c *Camera) Autofocus(bvh *BVH) bool {
+	direction := c.orientation.w.Mul(-1)
+	h, ok := bvh.Intersect(c.orientation.origin, direction, 0.001, math.Inf(1))
+	if !ok {
+		return false
+	}
+	lens := c.lens
+	lens.FocusDistance = h.Distance
+	c.SetLens(lens)
+	return true
+}
+
+// Places the viewport on the plane in focus, so that rays from any point on the lens meet there
+func (c *Camera) updateViewport() {
+	focus := c.lens.FocusDistance
+	if focus <= 0 {
+		focus = 1
+	}
+	c.horizontal = c.orientation.u.Mul(c.viewportWidth * focus)
+	c.vertical = c.orientation.v.Mul(c.viewportHeight * focus)
+	c.lowerLeftCorner = c.orientation.origin.Sub(c.horizontal.Mul(0.5)).Sub(c.vertical.Mul(0.5)).Sub(c.orientation.w.Mul(focus))
 }
 
 func (c *Camera) Origin() Vector3 {
@@ -91,15 +152,62 @@ func (c *Camera) W() Vector3 {
 	return c.orientation.w
 }
 
-// Cast ray in new direction, while keeping origin the same
-func (c *Camera) castRayReuse(s, t float64, ray *ray) {
-	ray.reuseSameOrigin(c.lowerLeftCorner.Add(c.horizontal.Mul(s)).Add(c.vertical.Mul(t)).Sub(c.orientation.origin))
+// Cast ray through the viewport from a point on the lens drawn from sampler, or as given by the projection of the camera.
+// Returns false if s and t are outside of the projected image
+func (c *Camera) castRay(s, t float64, sampler Sampler, ray *ray) bool {
+	lensU, lensV := sampler.get2D()
+	ray.time = c.shutterOpen + sampler.get1D()*(c.shutterClose-c.shutterOpen)
+	if c.projection != nil {
+		origin, direction, ok := c.projection(s, t)
+		if !ok {
+			return false
+		}
+		ray.reuse(c.toWorld(origin).Add(c.orientation.origin), c.toWorld(direction))
+		return true
+	}
+	target := c.lowerLeftCorner.Add(c.horizontal.Mul(s)).Add(c.vertical.Mul(t))
+	if c.lens.Aperture <= 0 {
+		ray.reuse(c.orientation.origin, target.Sub(c.orientation.origin))
+		return true
+	}
+	x, y := c.lens.sample(lensU, lensV)
+	origin := c.orientation.origin.Add(c.orientation.u.Mul(x)).Add(c.orientation.v.Mul(y))
+	ray.reuse(origin, target.Sub(origin))
+	return true
+}
+
+// Transforms a vector from camera space to world space, without translation
+func (c *Camera) toWorld(v Vector3) Vector3 {
+	return c.orientation.u.Mul(v.X).Add(c.orientation.v.Mul(v.Y)).Add(c.orientation.w.Mul(v.Z))
+}
+
+// Maps the uniform numbers u and v to a uniform point on the aperture, relative to the lens center
+func (l Lens) sample(u, v float64) (float64, float64) {
+	if l.Blades < 3 {
+		radius := l.Aperture * math.Sqrt(u)
+		theta := 2 * math.Pi * v
+		return radius * math.Cos(theta), radius * math.Sin(theta)
+	}
+	// Uniformly choose one of the triangles between the center and two neighbouring corners with u, then sample within it
+	u *= float64(l.Blades)
+	blade := math.Min(math.Floor(u), float64(l.Blades-1))
+	u -= blade
+	angle := 2 * math.Pi / float64(l.Blades)
+	a := l.BladeRotation + blade*angle
+	b := a + angle
+	su := math.Sqrt(u)
+	u = su * (1 - v)
+	v = su - u
+	x := l.Aperture * (u*math.Cos(a) + v*math.Cos(b))
+	y := l.Aperture * (u*math.Sin(a) + v*math.Sin(b))
+	return x, y
 }
 
 type ray struct {
 	origin         Vector3
 	direction      Vector3
 	dirNormSquared float64
+	time           float64 // 0 at the start and 1 at the end transformation of moving nodes
 
 	invDirection Vector3
 	sign         [3]int
@@ -156,25 +264,3 @@ func (r *ray) reuse(origin Vector3, direction Vector3) {
 	r.dirNormSquared = dirNormSq
 	r.sign = sign
 }
-
-// Creates a new ray by overriding the already allocated ray
-func (r *ray) reuseSameOrigin(direction Vector3) {
-	invDirection := direction.Inverse()
-	sign := [3]int{}
-
-	if invDirection.X < 0 {
-		sign[0] = 1
-	}
-	if invDirection.Y < 0 {
-		sign[1] = 1
-	}
-	if invDirection.Z < 0 {
-		sign[2] = 1
-	}
-
-	dirNormSq := direction.LengthSquared()
-	r.direction = direction
-	r.invDirection = invDirection
-	r.dirNormSquared = dirNormSq
-	r.sign = sign
-}
@@ -0,0 +1,100 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLensSample(t *testing.T) {
+	tests := []struct {
+		name string
+		lens Lens
+	}{
+		{"circle", Lens{Aperture: 0.5}},
+		{"triangle", Lens{Aperture: 0.5, Blades: 3}},
+		{"hexagon", Lens{Aperture: 2, Blades: 6, BladeRotation: 0.3}},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		const samples = 100000
+		mean := NewVector3(0, 0, 0)
+		for i := 0; i < samples; i++ {
+			x, y := test.lens.sample(r.Float64(), r.Float64())
+			if !insideAperture(test.lens, x, y) {
+				t.Fatalf("%v: sample %v,%v lies outside of the aperture", test.name, x, y)
+			}
+			mean = mean.Add(NewVector3(x, y, 0).Mul(1.0 / samples))
+		}
+		// The apertures are symmetric around the center
+		if mean.Length() > 0.01*test.lens.Aperture {
+			t.Errorf("%v: mean of the samples is %v", test.name, mean)
+		}
+	}
+}
+
+// Whether x,y lies within the circle or regular polygon of the aperture
+func insideAperture(lens Lens, x, y float64) bool {
+	const epsilon = 1e-9
+	if lens.Blades < 3 {
+		return math.Hypot(x, y) <= lens.Aperture+epsilon
+	}
+	// Distance from the center to the edges of the polygon
+	angle := 2 * math.Pi / float64(lens.Blades)
+	apothem := lens.Aperture * math.Cos(angle/2)
+	for i := 0; i < lens.Blades; i++ {
+		normal := lens.BladeRotation + (float64(i)+0.5)*angle
+		if x*math.Cos(normal)+y*math.Sin(normal) > apothem+epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+func TestThinLensFocus(t *testing.T) {
+	transform := CameraTransformation{NewVector3(1, 2, 3), NewVector3(0, 0, 0), NewVector3(0, 1, 0)}
+	sampler := NewIndependentSampler().clone(rand.New(rand.NewSource(1)), 1, 0)
+	for _, lens := range []Lens{{}, {Aperture: 0.2, FocusDistance: 5}, {Aperture: 1, FocusDistance: 0.5, Blades: 5}} {
+		cam := NewThinLensCamera(1.5, 50, transform, lens)
+		for _, st := range [][2]float64{{0.5, 0.5}, {0, 0}, {0.9, 0.2}} {
+			var focused Vector3
+			for i := 0; i < 100; i++ {
+				r := ray{}
+				cam.castRay(st[0], st[1], sampler, &r)
+				lensOffset := r.origin.Sub(cam.Origin())
+				if lensOffset.Length() > lens.Aperture+1e-9 || math.Abs(lensOffset.Dot(cam.W())) > 1e-9 {
+					t.Fatalf("%+v: ray starts at %v, outside of the lens", lens, r.origin)
+				}
+				// All rays through the same point of the image meet on the plane in focus
+				target := r.position(1)
+				if i == 0 {
+					focused = target
+				} else if target.Sub(focused).Length() > 1e-9 {
+					t.Fatalf("%+v: rays through %v meet the focus plane at %v and %v", lens, st, focused, target)
+				}
+			}
+			focus := lens.FocusDistance
+			if focus == 0 {
+				focus = 1
+			}
+			if distance := cam.Origin().Sub(focused).Dot(cam.W()); math.Abs(distance-focus) > 1e-9 {
+				t.Errorf("%+v: plane in focus at %v, expected %v", lens, distance, focus)
+			}
+		}
+	}
+}
+
+func TestAutofocus(t *testing.T) {
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, -6), 1)}, Diffuse{})))
+	bvh := scene.Compile()
+	cam := NewThinLensCamera(1, 40, CameraTransformation{NewVector3(0, 0, 0), NewVector3(0, 0, -1), NewVector3(0, 1, 0)}, Lens{Aperture: 0.1, FocusDistance: 1})
+	if !cam.Autofocus(&bvh) || math.Abs(cam.Lens().FocusDistance-5) > 1e-9 {
+		t.Errorf("focus distance is %v, expected 5", cam.Lens().FocusDistance)
+	}
+	// The camera looks along -w, turn it away from the sphere
+	cam.SetFront(NewVector3(0, 0, -1))
+	if cam.Autofocus(&bvh) || math.Abs(cam.Lens().FocusDistance-5) > 1e-9 {
+		t.Error("focus distance changed without a hit")
+	}
+}
@@ -0,0 +1,209 @@
+package pt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Returned by Denoiser.DenoiseTo if the output buffer is not of the same size as the AOVBuffer
+var ErrBufferSize = errors.New("buffer sizes differ")
+
+// Weights of the 5x5 B3 spline kernel along one axis
+var atrousKernel = [5]float64{1.0 / 16, 1.0 / 4, 3.0 / 8, 1.0 / 4, 1.0 / 16}
+
+// Edge avoiding à-trous wavelet filter guided by the albedo, normal and depth of an AOVBuffer, similar to the spatial filter of SVGF.
+// Lighting is separated from the albedo before filtering, so that textures stay sharp
+type Denoiser struct {
+	Iterations  int     // passes of the 5x5 kernel, the footprint doubles with every pass
+	SigmaColor  float64 // tolerance of luminance differences in multiples of their estimated standard deviation
+	SigmaNormal float64 // exponent of the cosine between normals, higher values preserve more geometric edges
+	SigmaDepth  float64 // tolerance of relative depth differences per pixel of distance
+}
+
+func DefaultDenoiser() Denoiser {
+	return Denoiser{
+		Iterations:  5,
+		SigmaColor:  4,
+		SigmaNormal: 128,
+		SigmaDepth:  0.1,
+	}
+}
+
+// Returns the filtered radiance of in, using the post process of in
+func (d Denoiser) Denoise(in *AOVBuffer) (*FrameBuffer, error) {
+	out := NewFrameBuffer(in.width, in.height)
+	out.PostProcess = in.PostProcess
+	if err := d.DenoiseTo(in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Writes the filtered radiance of in into out, returns ErrBufferSize if out is not of the same size
+func (d Denoiser) DenoiseTo(in *AOVBuffer, out *FrameBuffer) error {
+	if in.width != out.width || in.height != out.height {
+		return fmt.Errorf("%w: denoiser output is %vx%v, AOVBuffer is %vx%v", ErrBufferSize, out.width, out.height, in.width, in.height)
+	}
+	n := len(in.buff)
+	albedo := make([]Color, n)
+	normals := make([]Vector3, n)
+	current := make([]Color, n)
+	next := make([]Color, n)
+	variance := make([]float64, n)
+	nextVariance := make([]float64, n)
+	for i := range in.buff {
+		px := &in.buff[i]
+		albedo[i] = demodulation(px.albedo)
+		current[i] = NewColor(px.color.X/albedo[i].X, px.color.Y/albedo[i].Y, px.color.Z/albedo[i].Z)
+		if px.hits > 0 && !px.normal.ApproxZero() {
+			normals[i] = px.normal.Unit()
+		}
+	}
+	d.estimateVariance(in, current, albedo, variance)
+
+	step := 1
+	for i := 0; i < d.Iterations; i++ {
+		parallelRows(in.height, func(y int) {
+			for x := 0; x < in.width; x++ {
+				d.filter(in, x, y, step, current, normals, variance, next, nextVariance)
+			}
+		})
+		current, next = next, current
+		variance, nextVariance = nextVariance, variance
+		step *= 2
+	}
+	for i := range current {
+		out.buff[i] = current[i].Blend(albedo[i])
+	}
+	return nil
+}
+
+// Albedo the radiance is divided by, channels too dark to separate the lighting keep a factor of 1
+func demodulation(albedo Color) Color {
+	factor := func(v float64) float64 {
+		if v < 0.01 {
+			return 1
+		}
+		return v
+	}
+	return NewColor(factor(albedo.X), factor(albedo.Y), factor(albedo.Z))
+}
+
+// Estimates the variance of the luminance of each pixel. Pixels with enough samples use their sample variance,
+// others the variance of their 5x5 neighbourhood
+func (d Denoiser) estimateVariance(in *AOVBuffer, colors []Color, albedo []Color, out []float64) {
+	parallelRows(in.height, func(y int) {
+		for x := 0; x < in.width; x++ {
+			i := y*in.width + x
+			px := &in.buff[i]
+			if px.samples >= 4 {
+				l := luminance(albedo[i])
+				out[i] = luminance(px.variance()) / float64(px.samples) / (l * l)
+				continue
+			}
+			sum, sumSquared, count := 0.0, 0.0, 0.0
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qx, qy := x+dx, y+dy
+					if qx < 0 || qy < 0 || qx >= in.width || qy >= in.height {
+						continue
+					}
+					l := luminance(colors[qy*in.width+qx])
+					sum += l
+					sumSquared += l * l
+					count++
+				}
+			}
+			mean := sum / count
+			out[i] = math.Max(0, sumSquared/count-mean*mean)
+		}
+	})
+}
+
+// One pass of the kernel with holes of size step at pixel x,y
+func (d Denoiser) filter(in *AOVBuffer, x, y, step int, colors []Color, normals []Vector3, variance []float64, out []Color, outVariance []float64) {
+	p := y*in.width + x
+	pixel := &in.buff[p]
+	luminanceP := luminance(colors[p])
+	// The variance is blurred to be robust against outliers
+	sigma := d.SigmaColor*math.Sqrt(blurredVariance(in, x, y, variance)) + 1e-6
+
+	weightSum := 0.0
+	color := Color{}
+	varianceSum := 0.0
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			qx, qy := x+dx*step, y+dy*step
+			if qx < 0 || qy < 0 || qx >= in.width || qy >= in.height {
+				continue
+			}
+			q := qy*in.width + qx
+			w := atrousKernel[dx+2] * atrousKernel[dy+2]
+			if q != p {
+				w *= d.edgeWeight(pixel, &in.buff[q], normals[p], normals[q], step)
+				w *= math.Exp(-math.Abs(luminanceP-luminance(colors[q])) / sigma)
+			}
+			weightSum += w
+			color = color.Add(colors[q].Scale(w))
+			varianceSum += w * w * variance[q]
+		}
+	}
+	out[p] = color.Div(weightSum)
+	outVariance[p] = varianceSum / (weightSum * weightSum)
+}
+
+// Weight of pixel q in the filter of pixel p based on the difference of their geometry
+func (d Denoiser) edgeWeight(p, q *aovPixel, normalP, normalQ Vector3, step int) float64 {
+	if p.hits == 0 || q.hits == 0 {
+		// Only background is filtered with background
+		if p.hits == q.hits {
+			return 1
+		}
+		return 0
+	}
+	normal := math.Pow(math.Max(0, normalP.Dot(normalQ)), d.SigmaNormal)
+	depth := math.Exp(-math.Abs(p.depth-q.depth) / (d.SigmaDepth*float64(step)*p.depth + 1e-6))
+	return normal * depth
+}
+
+// 3x3 gaussian blur of the variance at x,y
+func blurredVariance(in *AOVBuffer, x, y int, variance []float64) float64 {
+	kernel := [3]float64{0.25, 0.5, 0.25}
+	sum, weightSum := 0.0, 0.0
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			qx, qy := x+dx, y+dy
+			if qx < 0 || qy < 0 || qx >= in.width || qy >= in.height {
+				continue
+			}
+			w := kernel[dx+1] * kernel[dy+1]
+			sum += w * variance[qy*in.width+qx]
+			weightSum += w
+		}
+	}
+	return sum / weightSum
+}
+
+// Calls row for all rows below height, distributed over all threads
+func parallelRows(height int, row func(y int)) {
+	threads := runtime.GOMAXPROCS(0)
+	jobs := make(chan int, height)
+	for y := 0; y < height; y++ {
+		jobs <- y
+	}
+	close(jobs)
+	wg := sync.WaitGroup{}
+	wg.Add(threads)
+	for t := 0; t < threads; t++ {
+		go func() {
+			defer wg.Done()
+			for y := range jobs {
+				row(y)
+			}
+		}()
+	}
+	wg.Wait()
+}
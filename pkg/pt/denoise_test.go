@@ -0,0 +1,87 @@
+package pt
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// AOVBuffer of two planes meeting at the center column, the left one lit with radiance left and the right one with right.
+// Every pixel receives spp samples with noise of the given amplitude
+func denoiseTestBuffer(r *rand.Rand, width, height, spp int, left, right, noise float64) *AOVBuffer {
+	buff := NewAOVBuffer(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			s := newAOVSample()
+			s.hit = true
+			s.albedo = NewColor(.5, .5, .5)
+			radiance := left
+			s.depth, s.normal = 4, NewVector3(0, 0, 1)
+			if x >= width/2 {
+				radiance = right
+				s.depth, s.normal = 2, NewVector3(1, 0, 0)
+			}
+			for i := 0; i < spp; i++ {
+				v := radiance + noise*(r.Float64()*2-1)
+				buff.addAOVSample(x, y, NewColor(v, v, v), &s)
+			}
+		}
+	}
+	return buff
+}
+
+func TestDenoiseConstant(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	in := denoiseTestBuffer(r, 16, 12, 4, 0.3, 0.3, 0)
+	out, err := DefaultDenoiser().Denoise(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range out.buff {
+		if Vector3(c).Sub(NewVector3(0.3, 0.3, 0.3)).Length() > 1e-9 {
+			t.Fatalf("pixel %v of a constant image was denoised to %v", i, c)
+		}
+	}
+}
+
+func TestDenoiseNoise(t *testing.T) {
+	const width, height = 32, 32
+	r := rand.New(rand.NewSource(1))
+	in := denoiseTestBuffer(r, width, height, 4, 0.2, 0.8, 0.2)
+	out, err := DefaultDenoiser().Denoise(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noisyError, denoisedError := 0.0, 0.0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			expected := 0.2
+			if x >= width/2 {
+				expected = 0.8
+			}
+			noisy, denoised := in.buff[y*width+x].color.X, out.buff[y*width+x].X
+			noisyError += (noisy - expected) * (noisy - expected)
+			denoisedError += (denoised - expected) * (denoised - expected)
+			// Pixels next to the edge are not blurred across it
+			if (x == width/2-1 || x == width/2) && math.Abs(denoised-expected) > 0.1 {
+				t.Errorf("pixel %v,%v next to the edge was denoised to %v, expected %v", x, y, denoised, expected)
+			}
+		}
+	}
+	if denoisedError > noisyError/4 {
+		t.Errorf("denoising reduced the squared error from %v to %v", noisyError, denoisedError)
+	}
+}
+
+func TestDenoiseToBufferSize(t *testing.T) {
+	in := NewAOVBuffer(4, 3)
+	for _, out := range []*FrameBuffer{NewFrameBuffer(3, 4), NewFrameBuffer(4, 4), NewFrameBuffer(0, 0)} {
+		if err := DefaultDenoiser().DenoiseTo(in, out); !errors.Is(err, ErrBufferSize) {
+			t.Errorf("denoising into %vx%v returned %v, expected ErrBufferSize", out.width, out.height, err)
+		}
+	}
+	if err := DefaultDenoiser().DenoiseTo(in, NewFrameBuffer(4, 3)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,98 @@
+package pt
+
+import (
+	"sort"
+)
+
+// Piecewise constant distribution over [0,1) proportional to function
+type distribution1D struct {
+	function []float64
+	cdf      []float64
+	integral float64
+}
+
+func newDistribution1D(function []float64) distribution1D {
+	n := len(function)
+	cdf := make([]float64, n+1)
+	for i, f := range function {
+		cdf[i+1] = cdf[i] + f/float64(n)
+	}
+	integral := cdf[n]
+	for i := 1; i <= n; i++ {
+		if integral == 0 {
+			// Fall back to a uniform distribution
+			cdf[i] = float64(i) / float64(n)
+		} else {
+			cdf[i] /= integral
+		}
+	}
+	return distribution1D{
+		function: function,
+		cdf:      cdf,
+		integral: integral,
+	}
+}
+
+// Maps the uniform sample u to the distribution, returns the sampled value, its pdf and the index of its segment
+func (d *distribution1D) sample(u float64) (float64, float64, int) {
+	n := len(d.function)
+	i := sort.Search(n, func(i int) bool { return d.cdf[i+1] > u })
+	if i == n {
+		i = n - 1
+	}
+	du := u - d.cdf[i]
+	if width := d.cdf[i+1] - d.cdf[i]; width > 0 {
+		du /= width
+	}
+	return (float64(i) + du) / float64(n), d.pdf(i), i
+}
+
+func (d *distribution1D) pdf(i int) float64 {
+	if d.integral == 0 {
+		return 1
+	}
+	return d.function[i] / d.integral
+}
+
+// Piecewise constant distribution over [0,1)² given by rows of function values
+type distribution2D struct {
+	conditional []distribution1D // distribution within each row
+	marginal    distribution1D   // distribution of the rows
+}
+
+func newDistribution2D(function []float64, width, height int) distribution2D {
+	conditional := make([]distribution1D, height)
+	rows := make([]float64, height)
+	for y := 0; y < height; y++ {
+		conditional[y] = newDistribution1D(function[y*width : (y+1)*width])
+		rows[y] = conditional[y].integral
+	}
+	return distribution2D{
+		conditional: conditional,
+		marginal:    newDistribution1D(rows),
+	}
+}
+
+// Maps the uniform samples u and v to the distribution, returns the sampled point and its pdf
+func (d *distribution2D) sample(u, v float64) (float64, float64, float64) {
+	y, pdfY, row := d.marginal.sample(v)
+	x, pdfX, _ := d.conditional[row].sample(u)
+	return x, y, pdfX * pdfY
+}
+
+func (d *distribution2D) pdf(x, y float64) float64 {
+	row := clampIndex(int(y*float64(len(d.conditional))), len(d.conditional))
+	conditional := &d.conditional[row]
+	column := clampIndex(int(x*float64(len(conditional.function))), len(conditional.function))
+	return d.marginal.pdf(row) * conditional.pdf(column)
+}
+
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
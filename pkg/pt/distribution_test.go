@@ -0,0 +1,93 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var distributionTests = []struct {
+	name     string
+	function []float64
+}{
+	{"uniform", []float64{1, 1, 1, 1}},
+	{"single", []float64{3}},
+	{"zeros", []float64{0, 2, 0, 0, 6, 1}},
+	{"spike", []float64{0.001, 0.001, 100, 0.001}},
+	{"all zero", []float64{0, 0, 0}},
+}
+
+func TestDistribution1D(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, test := range distributionTests {
+		d := newDistribution1D(test.function)
+		n := len(test.function)
+		sum := 0.0
+		for _, f := range test.function {
+			sum += f
+		}
+		const samples = 100000
+		histogram := make([]float64, n)
+		for i := 0; i < samples; i++ {
+			x, pdf, segment := d.sample(r.Float64())
+			if x < 0 || x >= 1 || segment != clampIndex(int(x*float64(n)), n) {
+				t.Fatalf("%v: sampled %v in segment %v", test.name, x, segment)
+			}
+			if pdf != d.pdf(segment) || pdf <= 0 {
+				t.Fatalf("%v: sampled %v with pdf %v, pdf of its segment is %v", test.name, x, pdf, d.pdf(segment))
+			}
+			histogram[segment] += 1.0 / samples
+		}
+		for i, f := range test.function {
+			expected := 1 / float64(n)
+			if sum > 0 {
+				expected = f / sum
+			}
+			// The pdf is a density over [0,1), each segment has a width of 1/n
+			if math.Abs(d.pdf(i)/float64(n)-expected) > 1e-12 || math.Abs(histogram[i]-expected) > 0.01 {
+				t.Errorf("%v: segment %v has pdf %v and was sampled with frequency %v, expected %v", test.name, i, d.pdf(i), histogram[i], expected)
+			}
+		}
+	}
+}
+
+func TestDistribution2D(t *testing.T) {
+	const width, height = 6, 5
+	r := rand.New(rand.NewSource(1))
+	function := make([]float64, width*height)
+	for i := range function {
+		if r.Float64() < 0.3 {
+			continue
+		}
+		function[i] = r.Float64() * 10
+	}
+	for name, f := range map[string][]float64{"random": function, "all zero": make([]float64, width*height)} {
+		d := newDistribution2D(f, width, height)
+		sum := 0.0
+		for _, v := range f {
+			sum += v
+		}
+		const samples = 200000
+		histogram := make([]float64, width*height)
+		for i := 0; i < samples; i++ {
+			x, y, pdf := d.sample(r.Float64(), r.Float64())
+			if x < 0 || x >= 1 || y < 0 || y >= 1 {
+				t.Fatalf("%v: sampled %v,%v", name, x, y)
+			}
+			if expected := d.pdf(x, y); math.Abs(pdf-expected) > 1e-9*expected || pdf <= 0 {
+				t.Fatalf("%v: sampled %v,%v with pdf %v, expected %v", name, x, y, pdf, expected)
+			}
+			histogram[int(y*height)*width+int(x*width)] += 1.0 / samples
+		}
+		for i, v := range f {
+			expected := 1.0 / (width * height)
+			if sum > 0 {
+				expected = v / sum
+			}
+			x, y := (float64(i%width)+0.5)/width, (float64(i/width)+0.5)/height
+			if math.Abs(d.pdf(x, y)/(width*height)-expected) > 1e-12 || math.Abs(histogram[i]-expected) > 0.005 {
+				t.Errorf("%v: cell %v has pdf %v and was sampled with frequency %v, expected %v", name, i, d.pdf(x, y), histogram[i], expected)
+			}
+		}
+	}
+}
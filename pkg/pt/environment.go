@@ -0,0 +1,116 @@
+package pt
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Light arriving from infinitely far away in every direction, like an environment map or the sky
+type Environment interface {
+	// Radiance arriving from the opposite of direction
+	radiance(direction Vector3) Color
+	// Samples a unit direction, returns the direction and its pdf with respect to solid angle
+	sample(u, v float64) (Vector3, float64)
+	// Pdf with respect to solid angle of sampling the unit vector direction through sample
+	pdf(direction Vector3) float64
+}
+
+// Distant light surrounding the scene, given by an equirectangular (latitude-longitude) image.
+// The center of the image is seen when looking along -z, the top row when looking along +y
+type EnvironmentMap struct {
+	Rotation  float64 // rotation around the y axis in radians
+	Intensity float64 // scale of the radiance of all pixels
+
+	width        int
+	height       int
+	pixels       []Color // radiance, row major starting at the top left corner
+	distribution distribution2D
+}
+
+// Creates an environment map from row major pixels starting at the top left corner
+func NewEnvironmentMap(width, height int, pixels []Color) *EnvironmentMap {
+	// Pixels are sampled proportional to their luminance and solid angle, which shrinks towards the poles
+	weights := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / float64(height))
+		for x := 0; x < width; x++ {
+			weights[y*width+x] = luminance(pixels[y*width+x]) * sinTheta
+		}
+	}
+	return &EnvironmentMap{
+		Intensity:    1,
+		width:        width,
+		height:       height,
+		pixels:       pixels,
+		distribution: newDistribution2D(weights, width, height),
+	}
+}
+
+// Loads an equirectangular Radiance .hdr or Portable Float Map .pfm image
+func LoadEnvironmentMap(path string) (*EnvironmentMap, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var width, height int
+	var pixels []Color
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hdr":
+		width, height, pixels, err = decodeHDR(file)
+	case ".pfm":
+		width, height, pixels, err = decodePFM(file)
+	default:
+		err = ErrUnsupportedImage
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return NewEnvironmentMap(width, height, pixels), nil
+}
+
+func (e *EnvironmentMap) radiance(direction Vector3) Color {
+	u, v := e.texCoord(direction.Unit())
+	x := clampIndex(int(u*float64(e.width)), e.width)
+	y := clampIndex(int(v*float64(e.height)), e.height)
+	return e.pixels[y*e.width+x].Scale(e.Intensity)
+}
+
+// Samples a direction proportional to the radiance
+func (e *EnvironmentMap) sample(u, v float64) (Vector3, float64) {
+	u, v, pdf := e.distribution.sample(u, v)
+	theta := v * math.Pi
+	sinTheta := math.Sin(theta)
+	if pdf == 0 || sinTheta == 0 {
+		return Vector3{}, 0
+	}
+	phi := (u-0.5)*2*math.Pi + e.Rotation
+	direction := NewVector3(sinTheta*math.Sin(phi), math.Cos(theta), -sinTheta*math.Cos(phi))
+	// Change of variables from the image to the sphere
+	return direction, pdf / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+func (e *EnvironmentMap) pdf(direction Vector3) float64 {
+	u, v := e.texCoord(direction)
+	sinTheta := math.Sin(v * math.Pi)
+	if sinTheta == 0 {
+		return 0
+	}
+	return e.distribution.pdf(u, v) / (2 * math.Pi * math.Pi * sinTheta)
+}
+
+// Image coordinates of the unit vector direction, starting at the top left corner
+func (e *EnvironmentMap) texCoord(direction Vector3) (float64, float64) {
+	phi := math.Atan2(direction.X, -direction.Z) - e.Rotation
+	u := phi/(2*math.Pi) + 0.5
+	u -= math.Floor(u)
+	v := math.Acos(Clamp(direction.Y, -1, 1)) / math.Pi
+	return u, v
+}
+
+func luminance(c Color) float64 {
+	return 0.2126*c.X + 0.7152*c.Y + 0.0722*c.Z
+}
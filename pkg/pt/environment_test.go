@@ -0,0 +1,84 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func testEnvironmentMap(r *rand.Rand) *EnvironmentMap {
+	const width, height = 16, 8
+	pixels := make([]Color, width*height)
+	for i := range pixels {
+		pixels[i] = NewColor(r.Float64(), r.Float64(), r.Float64())
+	}
+	// Bright sun, which most samples should be drawn from
+	pixels[2*width+5] = NewColor(500, 500, 400)
+	return NewEnvironmentMap(width, height, pixels)
+}
+
+func TestEnvironmentMapSample(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, rotation := range []float64{0, 1.3} {
+		env := testEnvironmentMap(r)
+		env.Rotation = rotation
+		for i := 0; i < 10000; i++ {
+			direction, pdf := env.sample(r.Float64(), r.Float64())
+			if pdf == 0 {
+				continue
+			}
+			if math.Abs(direction.Length()-1) > 1e-9 {
+				t.Fatalf("sampled direction %v is not a unit vector", direction)
+			}
+			if expected := env.pdf(direction); math.Abs(pdf-expected) > 1e-6*expected {
+				t.Fatalf("rotation %v: sampled %v with pdf %v, expected %v", rotation, direction, pdf, expected)
+			}
+		}
+	}
+}
+
+func TestEnvironmentMapPdfIntegratesToOne(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	env := testEnvironmentMap(r)
+	// Midpoint rule over the sphere, which has a uniform area element in z and phi
+	const steps = 1000
+	integral := 0.0
+	for i := 0; i < steps; i++ {
+		z := -1 + (float64(i)+0.5)/steps*2
+		radius := math.Sqrt(1 - z*z)
+		for j := 0; j < steps; j++ {
+			phi := (float64(j) + 0.5) / steps * 2 * math.Pi
+			integral += env.pdf(NewVector3(radius*math.Cos(phi), radius*math.Sin(phi), z)) * 4 * math.Pi / (steps * steps)
+		}
+	}
+	if math.Abs(integral-1) > 0.01 {
+		t.Errorf("pdf integrates to %v", integral)
+	}
+}
+
+func TestEnvironmentMapRadiance(t *testing.T) {
+	pixels := make([]Color, 4*2)
+	pixels[0*4+2] = NewColor(1, 0, 0) // top row, right of the center
+	pixels[1*4+1] = NewColor(0, 1, 0) // bottom row, left of the center
+	env := NewEnvironmentMap(4, 2, pixels)
+	env.Intensity = 2
+	tests := []struct {
+		direction Vector3
+		expected  Color
+	}{
+		{NewVector3(0.5, 0.5, -1), NewColor(2, 0, 0)},
+		{NewVector3(-0.5, -0.5, -1), NewColor(0, 2, 0)},
+		{NewVector3(0.5, -0.5, -1), NewColor(0, 0, 0)},
+		{NewVector3(0, 0.5, 1), NewColor(0, 0, 0)},
+	}
+	for _, test := range tests {
+		if got := env.radiance(test.direction); got != test.expected {
+			t.Errorf("radiance from %v is %v, expected %v", test.direction, got, test.expected)
+		}
+	}
+	// Rotating the map by a quarter turn moves the red pixel from -z to +x
+	env.Rotation = math.Pi / 2
+	if got := env.radiance(NewVector3(1, 0.5, 0.5)); got != NewColor(2, 0, 0) {
+		t.Errorf("radiance of the rotated map is %v", got)
+	}
+}
@@ -0,0 +1,181 @@
+package pt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+type EXRCompression int
+
+const (
+	EXRNoCompression EXRCompression = iota
+	EXRZIPCompression
+)
+
+const (
+	exrMagic        = 20000630
+	exrVersion      = 2
+	exrPixelFloat   = 2
+	exrZIPScanlines = 16
+)
+
+// Channel of an OpenEXR image, values are stored row major starting at the top left corner
+type exrChannel struct {
+	name   string
+	values []float32
+}
+
+// Writes a single part scanline OpenEXR file with 32 bit float channels
+func writeEXR(w io.Writer, width, height int, channels []exrChannel, compression EXRCompression) error {
+	// Readers expect the channels in alphabetical order
+	sorted := make([]exrChannel, len(channels))
+	copy(sorted, channels)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+
+	header := &bytes.Buffer{}
+	writeInt32(header, exrMagic)
+	writeInt32(header, exrVersion)
+
+	chlist := &bytes.Buffer{}
+	for _, ch := range sorted {
+		chlist.WriteString(ch.name)
+		chlist.WriteByte(0)
+		writeInt32(chlist, exrPixelFloat)
+		// pLinear and reserved bytes
+		chlist.Write([]byte{0, 0, 0, 0})
+		// x and y sampling
+		writeInt32(chlist, 1)
+		writeInt32(chlist, 1)
+	}
+	chlist.WriteByte(0)
+	writeEXRAttribute(header, "channels", "chlist", chlist.Bytes())
+
+	compressionAttribute := byte(0)
+	scanlines := 1
+	if compression == EXRZIPCompression {
+		compressionAttribute = 3
+		scanlines = exrZIPScanlines
+	}
+	writeEXRAttribute(header, "compression", "compression", []byte{compressionAttribute})
+
+	window := &bytes.Buffer{}
+	writeInt32(window, 0)
+	writeInt32(window, 0)
+	writeInt32(window, int32(width-1))
+	writeInt32(window, int32(height-1))
+	writeEXRAttribute(header, "dataWindow", "box2i", window.Bytes())
+	writeEXRAttribute(header, "displayWindow", "box2i", window.Bytes())
+
+	// Increasing y
+	writeEXRAttribute(header, "lineOrder", "lineOrder", []byte{0})
+	writeEXRAttribute(header, "pixelAspectRatio", "float", float32Bytes(1))
+	writeEXRAttribute(header, "screenWindowCenter", "v2f", append(float32Bytes(0), float32Bytes(0)...))
+	writeEXRAttribute(header, "screenWindowWidth", "float", float32Bytes(1))
+	header.WriteByte(0)
+
+	// Each chunk contains the channels of a block of scanlines, one after another per scanline
+	chunkCount := (height + scanlines - 1) / scanlines
+	chunks := make([][]byte, chunkCount)
+	raw := &bytes.Buffer{}
+	for i := range chunks {
+		raw.Reset()
+		first := i * scanlines
+		last := first + scanlines
+		if last > height {
+			last = height
+		}
+		for y := first; y < last; y++ {
+			for _, ch := range sorted {
+				binary.Write(raw, binary.LittleEndian, ch.values[y*width:(y+1)*width])
+			}
+		}
+		data := raw.Bytes()
+		if compression == EXRZIPCompression {
+			compressed, err := exrZIP(data)
+			if err != nil {
+				return err
+			}
+			// Readers treat data that is not smaller than the raw size as uncompressed
+			if len(compressed) < len(data) {
+				data = compressed
+			}
+		}
+		chunk := &bytes.Buffer{}
+		writeInt32(chunk, int32(first))
+		writeInt32(chunk, int32(len(data)))
+		chunk.Write(data)
+		chunks[i] = chunk.Bytes()
+	}
+
+	offsets := make([]uint64, chunkCount)
+	offset := uint64(header.Len() + 8*chunkCount)
+	for i, chunk := range chunks {
+		offsets[i] = offset
+		offset += uint64(len(chunk))
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, offsets); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compresses a block of scanlines the way the OpenEXR ZIP compression expects it
+func exrZIP(data []byte) ([]byte, error) {
+	// Split into even and odd bytes to group similar bytes of the floats
+	tmp := make([]byte, len(data))
+	half := (len(data) + 1) / 2
+	for i, v := range data {
+		if i%2 == 0 {
+			tmp[i/2] = v
+		} else {
+			tmp[half+i/2] = v
+		}
+	}
+	// Delta encode
+	for i := len(tmp) - 1; i > 0; i-- {
+		tmp[i] = byte(int(tmp[i]) - int(tmp[i-1]) + 128)
+	}
+
+	out := &bytes.Buffer{}
+	zw := zlib.NewWriter(out)
+	if _, err := zw.Write(tmp); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func writeEXRAttribute(buff *bytes.Buffer, name, typ string, value []byte) {
+	buff.WriteString(name)
+	buff.WriteByte(0)
+	buff.WriteString(typ)
+	buff.WriteByte(0)
+	writeInt32(buff, int32(len(value)))
+	buff.Write(value)
+}
+
+func writeInt32(buff *bytes.Buffer, v int32) {
+	binary.Write(buff, binary.LittleEndian, v)
+}
+
+func float32Bytes(v float32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+	return b
+}
@@ -0,0 +1,153 @@
+package pt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// Minimal reader for the single part scanline files written by writeEXR, returns the channels by name
+func readTestEXR(t *testing.T, data []byte, width, height int) map[string][]float32 {
+	in := bytes.NewReader(data)
+	var magic, version int32
+	binary.Read(in, binary.LittleEndian, &magic)
+	binary.Read(in, binary.LittleEndian, &version)
+	if magic != exrMagic || version != exrVersion {
+		t.Fatalf("magic number %v and version %v", magic, version)
+	}
+	readString := func() string {
+		s := []byte{}
+		for {
+			b, err := in.ReadByte()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b == 0 {
+				return string(s)
+			}
+			s = append(s, b)
+		}
+	}
+	attributes := map[string][]byte{}
+	for {
+		name := readString()
+		if name == "" {
+			break
+		}
+		readString()
+		var size int32
+		binary.Read(in, binary.LittleEndian, &size)
+		value := make([]byte, size)
+		io.ReadFull(in, value)
+		attributes[name] = value
+	}
+	for _, name := range []string{"channels", "compression", "dataWindow", "displayWindow", "lineOrder", "pixelAspectRatio", "screenWindowCenter", "screenWindowWidth"} {
+		if _, ok := attributes[name]; !ok {
+			t.Fatalf("missing required attribute %v", name)
+		}
+	}
+	var window [4]int32
+	binary.Read(bytes.NewReader(attributes["dataWindow"]), binary.LittleEndian, &window)
+	if window != [4]int32{0, 0, int32(width - 1), int32(height - 1)} {
+		t.Fatalf("data window %v", window)
+	}
+	names := []string{}
+	channelList := bytes.NewReader(attributes["channels"])
+	for {
+		name := []byte{}
+		for b, _ := channelList.ReadByte(); b != 0; b, _ = channelList.ReadByte() {
+			name = append(name, b)
+		}
+		if len(name) == 0 {
+			break
+		}
+		// Names are followed by the type, flags and sampling
+		channelList.Seek(16, io.SeekCurrent)
+		names = append(names, string(name))
+	}
+	scanlines := 1
+	if attributes["compression"][0] == 3 {
+		scanlines = exrZIPScanlines
+	}
+
+	channels := map[string][]float32{}
+	for _, name := range names {
+		channels[name] = make([]float32, width*height)
+	}
+	offsets := make([]uint64, (height+scanlines-1)/scanlines)
+	binary.Read(in, binary.LittleEndian, offsets)
+	for _, offset := range offsets {
+		var y, size int32
+		chunk := bytes.NewReader(data[offset:])
+		binary.Read(chunk, binary.LittleEndian, &y)
+		binary.Read(chunk, binary.LittleEndian, &size)
+		block := make([]byte, size)
+		io.ReadFull(chunk, block)
+		lines := height - int(y)
+		if lines > scanlines {
+			lines = scanlines
+		}
+		if rawSize := lines * len(names) * width * 4; int(size) < rawSize {
+			block = unzipTestEXR(t, block, rawSize)
+		}
+		values := make([]float32, len(block)/4)
+		binary.Read(bytes.NewReader(block), binary.LittleEndian, values)
+		for line := 0; line < lines; line++ {
+			for c, name := range names {
+				start := (line*len(names) + c) * width
+				copy(channels[name][(int(y)+line)*width:], values[start:start+width])
+			}
+		}
+	}
+	return channels
+}
+
+// Inverse of exrZIP
+func unzipTestEXR(t *testing.T, data []byte, size int) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := make([]byte, size)
+	if _, err := io.ReadFull(zr, tmp); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] = byte(int(tmp[i-1]) + int(tmp[i]) - 128)
+	}
+	out := make([]byte, size)
+	half := (size + 1) / 2
+	for i := range out {
+		if i%2 == 0 {
+			out[i] = tmp[i/2]
+		} else {
+			out[i] = tmp[half+i/2]
+		}
+	}
+	return out
+}
+
+func TestWriteEXRRoundTrip(t *testing.T) {
+	for _, compression := range []EXRCompression{EXRNoCompression, EXRZIPCompression} {
+		for _, size := range append(imageTestSizes, struct{ width, height int }{40, 37}) {
+			buff := testImageBuffer(size.width, size.height)
+			encoded := &bytes.Buffer{}
+			if err := buff.WriteEXR(encoded, compression); err != nil {
+				t.Fatal(err)
+			}
+			channels := readTestEXR(t, encoded.Bytes(), size.width, size.height)
+			if len(channels) != 3 {
+				t.Fatalf("got %v channels, expected R, G and B", len(channels))
+			}
+			for i := range channels["R"] {
+				c := topDownColor(buff, i%size.width, i/size.width)
+				got := [3]float32{channels["R"][i], channels["G"][i], channels["B"][i]}
+				if got != [3]float32{float32(c.X), float32(c.Y), float32(c.Z)} {
+					t.Fatalf("compression %v, %vx%v: pixel %v decoded to %v, expected %v", compression, size.width, size.height, i, got, c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package pt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// Writes the buffer as Radiance .hdr image using run length encoded RGBE pixels
+func (b *PixelBuffer) WriteHDR(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %v +X %v\n", b.height, b.width)
+	scanline := make([]byte, 4*b.width)
+	encoded := make([]byte, 0, 4*b.width+4)
+	for y := b.height - 1; y >= 0; y-- {
+		for x := 0; x < b.width; x++ {
+			rgbe(b.buff[y*b.width+x].color, scanline[4*x:4*x+4])
+		}
+		if b.width < 8 || b.width > 0x7fff {
+			// Run length encoding is only defined for these widths
+			out.Write(scanline)
+			continue
+		}
+		encoded = append(encoded[:0], 2, 2, byte(b.width>>8), byte(b.width&0xff))
+		// Each component is encoded separately
+		for i := 0; i < 4; i++ {
+			encoded = rleComponent(encoded, scanline, i)
+		}
+		out.Write(encoded)
+	}
+	return out.Flush()
+}
+
+// Encodes c into a shared exponent representation
+func rgbe(c Color, out []byte) {
+	v := math.Max(c.X, math.Max(c.Y, c.Z))
+	if v < 1e-32 {
+		out[0], out[1], out[2], out[3] = 0, 0, 0, 0
+		return
+	}
+	mantissa, exponent := math.Frexp(v)
+	scale := mantissa * 256 / v
+	out[0] = byte(math.Max(0, c.X*scale))
+	out[1] = byte(math.Max(0, c.Y*scale))
+	out[2] = byte(math.Max(0, c.Z*scale))
+	out[3] = byte(exponent + 128)
+}
+
+// Appends the run length encoding of the i-th component of the rgbe scanline to dst
+func rleComponent(dst []byte, scanline []byte, i int) []byte {
+	n := len(scanline) / 4
+	at := func(x int) byte {
+		return scanline[4*x+i]
+	}
+	x := 0
+	for x < n {
+		// Find the next run of at least 4 equal values
+		runStart := x
+		runLength := 0
+		for runStart < n {
+			runLength = 1
+			for runStart+runLength < n && runLength < 127 && at(runStart+runLength) == at(runStart) {
+				runLength++
+			}
+			if runLength >= 4 {
+				break
+			}
+			runStart += runLength
+		}
+		if runLength < 4 {
+			runStart = n
+		}
+		// Values before the run are written as literals
+		for x < runStart {
+			count := runStart - x
+			if count > 128 {
+				count = 128
+			}
+			dst = append(dst, byte(count))
+			for j := 0; j < count; j++ {
+				dst = append(dst, at(x+j))
+			}
+			x += count
+		}
+		if runStart < n {
+			dst = append(dst, byte(128+runLength), at(runStart))
+			x = runStart + runLength
+		}
+	}
+	return dst
+}
+
+// Writes the buffer as little endian Portable Float Map
+func (b *PixelBuffer) WritePFM(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	// A negative scale marks little endian data
+	fmt.Fprintf(out, "PF\n%v %v\n-1.0\n", b.width, b.height)
+	// Rows are stored bottom to top, same as the buffer
+	values := make([]float32, 3*b.width)
+	for y := 0; y < b.height; y++ {
+		for x := 0; x < b.width; x++ {
+			c := b.buff[y*b.width+x].color
+			values[3*x] = float32(c.X)
+			values[3*x+1] = float32(c.Y)
+			values[3*x+2] = float32(c.Z)
+		}
+		if err := binary.Write(out, binary.LittleEndian, values); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// Writes the buffer as scanline OpenEXR image with 32 bit float R, G and B channels
+func (b *PixelBuffer) WriteEXR(w io.Writer, compression EXRCompression) error {
+	r := make([]float32, len(b.buff))
+	g := make([]float32, len(b.buff))
+	bl := make([]float32, len(b.buff))
+	for y := 0; y < b.height; y++ {
+		// EXR rows are stored top to bottom
+		row := (b.height - 1 - y) * b.width
+		for x := 0; x < b.width; x++ {
+			c := b.buff[y*b.width+x].color
+			r[row+x] = float32(c.X)
+			g[row+x] = float32(c.Y)
+			bl[row+x] = float32(c.Z)
+		}
+	}
+	return writeEXR(w, b.width, b.height, []exrChannel{
+		{name: "R", values: r},
+		{name: "G", values: g},
+		{name: "B", values: bl},
+	}, compression)
+}
+
+// Decodes a Radiance .hdr image with flat or run length encoded RGBE pixels, rows start at the top left corner
+func decodeHDR(r io.Reader) (int, int, []Color, error) {
+	in := bufio.NewReader(r)
+	magic, err := in.ReadString('\n')
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if !strings.HasPrefix(magic, "#?") {
+		return 0, 0, nil, fmt.Errorf("%w: missing radiance header", ErrUnsupportedImage)
+	}
+	// Header lines end with an empty line
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return 0, 0, nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, line)
+		}
+	}
+	var width, height int
+	resolution, err := in.ReadString('\n')
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil || width <= 0 || height <= 0 {
+		return 0, 0, nil, fmt.Errorf("%w: resolution %q", ErrUnsupportedImage, strings.TrimSpace(resolution))
+	}
+
+	pixels := make([]Color, width*height)
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err := readHDRScanline(in, scanline); err != nil {
+			return 0, 0, nil, err
+		}
+		for x := 0; x < width; x++ {
+			pixels[y*width+x] = fromRGBE(scanline[4*x : 4*x+4])
+		}
+	}
+	return width, height, pixels, nil
+}
+
+func readHDRScanline(in *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+	if width < 8 || width > 0x7fff {
+		_, err := io.ReadFull(in, scanline)
+		return err
+	}
+	start, err := in.Peek(4)
+	if err != nil {
+		return err
+	}
+	if start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 {
+		// Not run length encoded
+		_, err := io.ReadFull(in, scanline)
+		return err
+	}
+	if int(start[2])<<8|int(start[3]) != width {
+		return fmt.Errorf("%w: scanline width mismatch", ErrUnsupportedImage)
+	}
+	in.Discard(4)
+	// Each component is encoded separately
+	for i := 0; i < 4; i++ {
+		for x := 0; x < width; {
+			count, err := in.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				run := int(count) - 128
+				value, err := in.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+run > width {
+					return fmt.Errorf("%w: run exceeds scanline", ErrUnsupportedImage)
+				}
+				for j := 0; j < run; j++ {
+					scanline[4*(x+j)+i] = value
+				}
+				x += run
+				continue
+			}
+			if count == 0 || x+int(count) > width {
+				return fmt.Errorf("%w: invalid literal count", ErrUnsupportedImage)
+			}
+			for j := 0; j < int(count); j++ {
+				value, err := in.ReadByte()
+				if err != nil {
+					return err
+				}
+				scanline[4*(x+j)+i] = value
+			}
+			x += int(count)
+		}
+	}
+	return nil
+}
+
+// Decodes a shared exponent color, inverse of rgbe
+func fromRGBE(in []byte) Color {
+	if in[3] == 0 {
+		return NewColor(0, 0, 0)
+	}
+	scale := math.Ldexp(1, int(in[3])-128-8)
+	return NewColor(float64(in[0])*scale, float64(in[1])*scale, float64(in[2])*scale)
+}
+
+// Decodes a color or grayscale Portable Float Map, rows start at the top left corner
+func decodePFM(r io.Reader) (int, int, []Color, error) {
+	in := bufio.NewReader(r)
+	var kind string
+	var width, height int
+	var scale float64
+	if _, err := fmt.Fscan(in, &kind, &width, &height, &scale); err != nil {
+		return 0, 0, nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	channels := 0
+	switch kind {
+	case "PF":
+		channels = 3
+	case "Pf":
+		channels = 1
+	}
+	if channels == 0 || width <= 0 || height <= 0 || scale == 0 {
+		return 0, 0, nil, fmt.Errorf("%w: invalid portable float map header", ErrUnsupportedImage)
+	}
+	// A single whitespace character separates the header from the data
+	if _, err := in.ReadByte(); err != nil {
+		return 0, 0, nil, err
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0 {
+		order = binary.LittleEndian
+	}
+
+	pixels := make([]Color, width*height)
+	values := make([]float32, channels*width)
+	// Rows are stored bottom to top
+	for y := height - 1; y >= 0; y-- {
+		if err := binary.Read(in, order, values); err != nil {
+			return 0, 0, nil, err
+		}
+		for x := 0; x < width; x++ {
+			if channels == 1 {
+				v := float64(values[x])
+				pixels[y*width+x] = NewColor(v, v, v)
+			} else {
+				pixels[y*width+x] = NewColor(float64(values[3*x]), float64(values[3*x+1]), float64(values[3*x+2]))
+			}
+		}
+	}
+	return width, height, pixels, nil
+}
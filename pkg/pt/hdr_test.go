@@ -0,0 +1,114 @@
+package pt
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Buffer with random colors over several orders of magnitude and runs of equal colors
+func testImageBuffer(width, height int) *PixelBuffer {
+	r := rand.New(rand.NewSource(1))
+	buff := NewPxlBuffer(width, height)
+	for i := range buff.buff {
+		c := NewColor(r.Float64(), r.Float64(), r.Float64()).Scale(math.Pow(10, float64(r.Intn(7)-3)))
+		if (i/3)%4 == 0 || i%width > width/2 {
+			c = NewColor(0.5, 0.25, 2)
+		}
+		buff.buff[i].addSample(c)
+	}
+	return buff
+}
+
+var imageTestSizes = []struct{ width, height int }{
+	{1, 1},
+	{5, 3},   // too narrow for run length encoding
+	{20, 7},  // run length encoded
+	{300, 2}, // runs and literals longer than a single count byte
+}
+
+// Color of the buffer at the given row of an image stored top to bottom
+func topDownColor(buff *PixelBuffer, x, row int) Color {
+	return buff.buff[(buff.height-1-row)*buff.width+x].color
+}
+
+func TestWriteHDRRoundTrip(t *testing.T) {
+	for _, size := range imageTestSizes {
+		buff := testImageBuffer(size.width, size.height)
+		encoded := &bytes.Buffer{}
+		if err := buff.WriteHDR(encoded); err != nil {
+			t.Fatal(err)
+		}
+		width, height, pixels, err := decodeHDR(encoded)
+		if err != nil {
+			t.Fatalf("%vx%v: %v", size.width, size.height, err)
+		}
+		if width != size.width || height != size.height {
+			t.Fatalf("decoded %vx%v image, expected %vx%v", width, height, size.width, size.height)
+		}
+		for i, got := range pixels {
+			expected := topDownColor(buff, i%width, i/width)
+			// The shared exponent leaves 8 bits of precision relative to the largest channel
+			tolerance := math.Max(expected.X, math.Max(expected.Y, expected.Z)) / 128
+			if math.Abs(got.X-expected.X) > tolerance || math.Abs(got.Y-expected.Y) > tolerance || math.Abs(got.Z-expected.Z) > tolerance {
+				t.Fatalf("%vx%v: pixel %v decoded to %v, expected %v", width, height, i, got, expected)
+			}
+		}
+	}
+}
+
+func TestWritePFMRoundTrip(t *testing.T) {
+	for _, size := range imageTestSizes {
+		buff := testImageBuffer(size.width, size.height)
+		encoded := &bytes.Buffer{}
+		if err := buff.WritePFM(encoded); err != nil {
+			t.Fatal(err)
+		}
+		width, height, pixels, err := decodePFM(encoded)
+		if err != nil {
+			t.Fatalf("%vx%v: %v", size.width, size.height, err)
+		}
+		if width != size.width || height != size.height {
+			t.Fatalf("decoded %vx%v image, expected %vx%v", width, height, size.width, size.height)
+		}
+		for i, got := range pixels {
+			c := topDownColor(buff, i%width, i/width)
+			expected := NewColor(float64(float32(c.X)), float64(float32(c.Y)), float64(float32(c.Z)))
+			if got != expected {
+				t.Fatalf("%vx%v: pixel %v decoded to %v, expected %v", width, height, i, got, expected)
+			}
+		}
+	}
+}
+
+func TestDecodeUnsupportedImages(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func(string) error
+		data   string
+	}{
+		{"hdr without header", decodeHDRString, "P6\n1 1\n255\n"},
+		{"hdr format", decodeHDRString, "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n"},
+		{"hdr flipped", decodeHDRString, "#?RADIANCE\n\n+Y 1 +X 1\n"},
+		{"pfm kind", decodePFMString, "P6\n1 1\n-1.0\n"},
+		{"pfm size", decodePFMString, "PF\n0 1\n-1.0\n"},
+	}
+	for _, test := range tests {
+		if err := test.decode(test.data); !errors.Is(err, ErrUnsupportedImage) {
+			t.Errorf("%v: expected %v, got %v", test.name, ErrUnsupportedImage, err)
+		}
+	}
+}
+
+func decodeHDRString(data string) error {
+	_, _, _, err := decodeHDR(strings.NewReader(data))
+	return err
+}
+
+func decodePFMString(data string) error {
+	_, _, _, err := decodePFM(strings.NewReader(data))
+	return err
+}
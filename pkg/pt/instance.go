@@ -0,0 +1,75 @@
+package pt
+
+// Mesh placed in the scene through a transformation.
+// The bottom level BVH of the mesh is shared between all instances, rays are transformed into its object space instead of copying the geometry
+type instance struct {
+	blas           *BVH
+	transformation Matrix4 // object to world space
+	inverse        Matrix4 // world to object space
+	normalMatrix   Matrix4 // transposed inverse, transforms object space normals to world space
+	box            aabb    // world space bounding box
+}
+
+func newInstance(blas *BVH, t Matrix4) *instance {
+	inverse := t.Inverse()
+	return &instance{
+		blas:           blas,
+		transformation: t,
+		inverse:        inverse,
+		normalMatrix:   inverse.Transpose(),
+		box:            transformedAABB(blas.root.bounding, t),
+	}
+}
+
+func (i *instance) intersected(r ray, tMin, tMax float64, hitOut *hit) bool {
+	return intersectedTransformed(i.blas, r, i.inverse, i.normalMatrix, tMin, tMax, hitOut)
+}
+
+func (i *instance) occluded(r ray, tMin, tMax float64) bool {
+	return i.blas.occluded(objectSpaceRay(r, i.inverse), tMin, tMax)
+}
+
+// Intersects blas with r transformed by inverse into object space, the hit is transformed back using normalMatrix
+func intersectedTransformed(blas *BVH, r ray, inverse, normalMatrix Matrix4, tMin, tMax float64, hitOut *hit) bool {
+	if !blas.intersected(objectSpaceRay(r, inverse), tMin, tMax, hitOut) {
+		return false
+	}
+	hitOut.point = r.position(hitOut.t)
+	hitOut.normal = hitOut.normal.ToVector().Transformed(normalMatrix).ToV3().Unit()
+	return true
+}
+
+// The direction is not normalized, which keeps t the same in object and world space
+func objectSpaceRay(r ray, inverse Matrix4) ray {
+	origin := r.origin.ToPoint().Transformed(inverse).ToV3()
+	direction := r.direction.ToVector().Transformed(inverse).ToV3()
+	object := newRay(origin, direction)
+	object.time = r.time
+	return object
+}
+
+func (i *instance) bounding() aabb {
+	return i.box
+}
+
+func (i *instance) transformed(t Matrix4) primitive {
+	return newInstance(i.blas, t.MultiplyMatrix(i.transformation))
+}
+
+// Bounding box of the 8 transformed corners of box
+func transformedAABB(box aabb, t Matrix4) aabb {
+	var result aabb
+	for corner := 0; corner < 8; corner++ {
+		p := NewVector3(
+			box.bounds[corner&1].X,
+			box.bounds[(corner>>1)&1].Y,
+			box.bounds[(corner>>2)&1].Z,
+		).ToPoint().Transformed(t).ToV3()
+		if corner == 0 {
+			result = newAABB(p, p)
+		} else {
+			result = result.add(newAABB(p, p))
+		}
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package pt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCompileInstancedMatchesCompile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	scene := bvhTestScene(r)
+	reference := scene.Compile()
+	instanced := scene.CompileInstanced()
+	expectSameHits(t, "instanced", &reference, &instanced, bvhTestRays(r, 2000))
+
+	// Nodes sharing a mesh share its bottom level BVH
+	blas := map[*BVH]int{}
+	for _, prim := range instanced.prims {
+		inst, ok := prim.prim.(*instance)
+		if !ok {
+			t.Fatalf("top level primitive %T is not an instance", prim.prim)
+		}
+		blas[inst.blas]++
+	}
+	if len(blas) != 4 {
+		t.Errorf("%v bottom level BVHs for 4 meshes", len(blas))
+	}
+	for _, count := range blas {
+		if count != 3 {
+			t.Errorf("bottom level BVH is used by %v instead of 3 instances", count)
+		}
+	}
+}
+
+func TestCompileInstancedLights(t *testing.T) {
+	geometry := Geometry{NewSphere(NewVector3(0, 0, 0), 1), NewSphere(NewVector3(0, 1, 0), 0.5)}
+	light := NewMesh(geometry, Light{Color: NewColor(1, 1, 1)})
+	diffuse := NewMesh(geometry, Diffuse{})
+	noMaterial := NewMesh(geometry, nil)
+	scene := NewScene()
+	for i, mesh := range []*Mesh{light, light, diffuse, diffuse, noMaterial, noMaterial} {
+		node := NewSceneNode(mesh)
+		node.Translate(float64(3*i), 0, 0)
+		scene.Add(node)
+	}
+	bvh := scene.CompileInstanced()
+	// Emissive meshes are not instanced, so that their primitives can be sampled
+	if len(bvh.lights) != 4 {
+		t.Errorf("%v lights, expected 4", len(bvh.lights))
+	}
+	instances := 0
+	for _, prim := range bvh.prims {
+		if _, ok := prim.prim.(*instance); ok {
+			instances++
+		}
+	}
+	if instances != 4 {
+		t.Errorf("%v instances, expected 4", instances)
+	}
+}
@@ -2,19 +2,26 @@ package pt
 
 import (
 	"math"
-	"math/rand"
 )
 
 type Material interface {
-	scatter(*ray, *hit, *rand.Rand) (bool, Color)
+	scatter(*ray, *hit, Sampler) (bool, Color)
 	emittedLight() Color
 }
 
+// Materials implementing bsdf can be evaluated for arbitrary pairs of directions, which is required for next event estimation.
+// Materials with a delta distribution, like Reflective and Refractive, can only be sampled through scatter
+type bsdf interface {
+	// Returns the bsdf multiplied by the cosine term for the incoming ray direction in and the scattered direction out,
+	// as well as the pdf of scatter choosing direction out
+	evaluate(in Vector3, out Vector3, h *hit) (Color, float64)
+}
+
 type Light struct {
 	Color Color
 }
 
-func (Light) scatter(*ray, *hit, *rand.Rand) (bool, Color) {
+func (Light) scatter(*ray, *hit, Sampler) (bool, Color) {
 	return false, Color{}
 }
 
@@ -23,45 +30,65 @@ func (l Light) emittedLight() Color {
 }
 
 type Diffuse struct {
-	Albedo Color
+	Albedo  Color
+	Texture Texture // replaces Albedo if set
 }
 
-func (d Diffuse) scatter(ray *ray, intersec *hit, r *rand.Rand) (bool, Color) {
-	scatterDirection := intersec.normal.Add(RandomUnitVector(r))
+func (d Diffuse) scatter(ray *ray, intersec *hit, s Sampler) (bool, Color) {
+	scatterDirection := intersec.normal.Add(sampleUnitSphere(s.get2D()))
 
 	if scatterDirection.ApproxZero() {
 		scatterDirection = intersec.normal
 	}
 
 	ray.reuse(intersec.point, scatterDirection)
-	return true, d.Albedo
+	return true, albedo(d.Albedo, d.Texture, intersec)
+}
+
+// Lambertian reflection, scatter samples the cosine weighted hemisphere
+func (d Diffuse) evaluate(in Vector3, out Vector3, h *hit) (Color, float64) {
+	cos := h.normal.Dot(out.Unit())
+	if cos <= 0 {
+		return Color{}, 0
+	}
+	return albedo(d.Albedo, d.Texture, h).Scale(cos / math.Pi), cos / math.Pi
 }
 
 func (Diffuse) emittedLight() Color {
 	return NewColor(0, 0, 0)
 }
 
+func (d Diffuse) surfaceAlbedo(h *hit) Color {
+	return albedo(d.Albedo, d.Texture, h)
+}
+
 type Reflective struct {
 	Albedo    Color
+	Texture   Texture // replaces Albedo if set
 	Diffusion float64 // diffusion in range [0,1]
 }
 
-func (d Reflective) scatter(ray *ray, intersec *hit, r *rand.Rand) (bool, Color) {
+func (d Reflective) scatter(ray *ray, intersec *hit, s Sampler) (bool, Color) {
 	reflected := reflect(ray.direction.Unit(), intersec.normal)
-	ray.reuse(intersec.point, reflected.Add(RandomUnitVector(r).Mul(d.Diffusion)))
-	return reflected.Dot(intersec.normal) > 0, d.Albedo
+	ray.reuse(intersec.point, reflected.Add(sampleUnitSphere(s.get2D()).Mul(d.Diffusion)))
+	return reflected.Dot(intersec.normal) > 0, albedo(d.Albedo, d.Texture, intersec)
 }
 
 func (Reflective) emittedLight() Color {
 	return NewColor(0, 0, 0)
 }
 
+func (d Reflective) surfaceAlbedo(h *hit) Color {
+	return albedo(d.Albedo, d.Texture, h)
+}
+
 type Refractive struct {
-	Albedo Color
-	Ratio  float64
+	Albedo  Color
+	Texture Texture // replaces Albedo if set
+	Ratio   float64
 }
 
-func (d Refractive) scatter(ray *ray, intersec *hit, r *rand.Rand) (bool, Color) {
+func (d Refractive) scatter(ray *ray, intersec *hit, s Sampler) (bool, Color) {
 	refractionRatio := d.Ratio
 	if intersec.frontFace {
 		refractionRatio = 1 / d.Ratio
@@ -74,19 +101,23 @@ func (d Refractive) scatter(ray *ray, intersec *hit, r *rand.Rand) (bool, Color)
 	cannot_refract := refractionRatio*sin_theta > 1.0
 
 	var direction Vector3
-	if cannot_refract || reflectance(cos_theta, refractionRatio) > rand.Float64() {
+	if cannot_refract || reflectance(cos_theta, refractionRatio) > s.get1D() {
 		direction = reflect(unitDir, intersec.normal)
 	} else {
 		direction = refract(unitDir, intersec.normal, refractionRatio)
 	}
 	ray.reuse(intersec.point, direction)
-	return true, d.Albedo
+	return true, albedo(d.Albedo, d.Texture, intersec)
 }
 
 func (Refractive) emittedLight() Color {
 	return NewColor(0, 0, 0)
 }
 
+func (d Refractive) surfaceAlbedo(h *hit) Color {
+	return albedo(d.Albedo, d.Texture, h)
+}
+
 func reflect(v Vector3, n Vector3) Vector3 {
 	return v.Sub(n.Mul(v.Dot(n) * 2))
 }
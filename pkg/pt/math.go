@@ -1,7 +1,6 @@
 package pt
 
 import (
-	"image/color"
 	"math"
 	"math/rand"
 )
@@ -16,12 +15,8 @@ func NewColor255(r, g, b int) Color {
 	return Color{float64(r) / 255.0, float64(g) / 255.0, float64(b) / 255.0}
 }
 
-// Convert to image color and also Gamma correct for gamma=2.0
-func (c Color) goColor() color.Color {
-	r := math.Sqrt(c.X)
-	g := math.Sqrt(c.Y)
-	b := math.Sqrt(c.Z)
-	return color.RGBA{R: uint8(Clamp(r, 0.0, 1.0) * 255), G: uint8(Clamp(g, 0.0, 1.0) * 255), B: uint8(Clamp(b, 0.0, 1.0) * 255), A: 255}
+func (c Color) isBlack() bool {
+	return c.X == 0 && c.Y == 0 && c.Z == 0
 }
 
 func (c1 Color) Blend(c2 Color) Color {
@@ -320,6 +315,15 @@ func (a Matrix4) MultiplyMatrix(b Matrix4) Matrix4 {
 	}
 }
 
+// Elementwise linear interpolation, points transformed by the result move linearly from a to b
+func (a Matrix4) lerp(b Matrix4, t float64) Matrix4 {
+	var out Matrix4
+	for i := range a {
+		out[i] = a[i] + t*(b[i]-a[i])
+	}
+	return out
+}
+
 type Quanternion struct {
 	a float64
 	v Vector3
@@ -0,0 +1,359 @@
+package pt
+
+import (
+	"math"
+)
+
+// Below this roughness microfacet materials are treated as perfectly smooth
+const SPECULAR_ROUGHNESS = 0.01
+
+// Complex indices of refraction for common metals at the wavelengths 650nm, 550nm and 450nm
+var (
+	Gold      = Conductor{Eta: NewColor(0.143, 0.374, 1.442), K: NewColor(3.983, 2.385, 1.603)}
+	Silver    = Conductor{Eta: NewColor(0.155, 0.117, 0.138), K: NewColor(4.828, 3.122, 2.147)}
+	Copper    = Conductor{Eta: NewColor(0.200, 0.924, 1.102), K: NewColor(3.912, 2.452, 2.142)}
+	Aluminium = Conductor{Eta: NewColor(1.657, 0.880, 0.521), K: NewColor(9.224, 6.270, 4.837)}
+)
+
+// Rough metal using the GGX (Trowbridge-Reitz) microfacet distribution
+type Conductor struct {
+	Eta       Color   // real part of the index of refraction per color channel
+	K         Color   // imaginary part of the index of refraction (absorption) per color channel
+	Roughness float64 // perceptual roughness in range [0,1]
+}
+
+// Returns a copy of the conductor with the given roughness
+func (c Conductor) WithRoughness(roughness float64) Conductor {
+	c.Roughness = roughness
+	return c
+}
+
+func (c Conductor) scatter(ray *ray, intersec *hit, s Sampler) (bool, Color) {
+	frame := newShadingFrame(intersec.normal)
+	wo := frame.toLocal(ray.direction.Unit().Mul(-1))
+	if c.Roughness < SPECULAR_ROUGHNESS {
+		wi := NewVector3(-wo.X, -wo.Y, wo.Z)
+		ray.reuse(intersec.point, frame.toWorld(wi))
+		return wo.Z > 0, c.fresnel(wo.Z)
+	}
+	alpha := c.Roughness * c.Roughness
+	u1, u2 := s.get2D()
+	m := sampleGGXVisibleNormal(wo, alpha, u1, u2)
+	wi := reflect(wo.Mul(-1), m)
+	if wi.Z <= 0 || wo.Z <= 0 {
+		return false, Color{}
+	}
+	ray.reuse(intersec.point, frame.toWorld(wi))
+	weight := smithG2(wo, wi, alpha) / smithG1(wo, alpha)
+	return true, c.fresnel(wi.Dot(m)).Scale(weight)
+}
+
+func (c Conductor) evaluate(in Vector3, out Vector3, h *hit) (Color, float64) {
+	if c.Roughness < SPECULAR_ROUGHNESS {
+		return Color{}, 0
+	}
+	frame := newShadingFrame(h.normal)
+	wo := frame.toLocal(in.Unit().Mul(-1))
+	wi := frame.toLocal(out.Unit())
+	if wo.Z <= 0 || wi.Z <= 0 {
+		return Color{}, 0
+	}
+	alpha := c.Roughness * c.Roughness
+	m := wo.Add(wi).Unit()
+	d := ggxD(m, alpha)
+	f := c.fresnel(wi.Dot(m)).Scale(d * smithG2(wo, wi, alpha) / (4 * wo.Z))
+	pdf := d * smithG1(wo, alpha) / (4 * wo.Z)
+	return f, pdf
+}
+
+func (c Conductor) fresnel(cos float64) Color {
+	return NewColor(
+		fresnelConductor(cos, c.Eta.X, c.K.X),
+		fresnelConductor(cos, c.Eta.Y, c.K.Y),
+		fresnelConductor(cos, c.Eta.Z, c.K.Z),
+	)
+}
+
+func (Conductor) emittedLight() Color {
+	return NewColor(0, 0, 0)
+}
+
+// Reflectance at normal incidence
+func (c Conductor) surfaceAlbedo(h *hit) Color {
+	return c.fresnel(1)
+}
+
+// Conductor whose reflectance at normal incidence is f0, which is limited to below 1
+func reflectanceConductor(f0 Color, roughness float64) Conductor {
+	// With a real index of 1 the reflectance at normal incidence is k²/(4+k²)
+	k := func(f float64) float64 {
+		f = Clamp(f, 0, 0.999)
+		return 2 * math.Sqrt(f/(1-f))
+	}
+	return Conductor{
+		Eta:       NewColor(1, 1, 1),
+		K:         NewColor(k(f0.X), k(f0.Y), k(f0.Z)),
+		Roughness: roughness,
+	}
+}
+
+// Diffuse base with a GGX specular lobe on top, like the Phong materials of .mtl files.
+// The lobes are added, so Albedo and Specular should not sum up to more than 1 to conserve energy
+type Glossy struct {
+	Albedo    Color
+	Texture   Texture // replaces Albedo if set
+	Specular  Color   // reflectance of the specular lobe at normal incidence
+	Roughness float64 // perceptual roughness of the specular lobe in range [SPECULAR_ROUGHNESS,1]
+}
+
+// Returns the lobes and the probability of sampling the specular lobe at h
+func (g Glossy) lobes(h *hit) (Diffuse, Conductor, float64) {
+	diffuse := Diffuse{Albedo: g.Albedo, Texture: g.Texture}
+	// The specular lobe stays rough, so that it can be evaluated for multiple importance sampling
+	specular := reflectanceConductor(g.Specular, math.Max(g.Roughness, SPECULAR_ROUGHNESS))
+	d := luminance(diffuse.surfaceAlbedo(h))
+	s := luminance(g.Specular)
+	if d+s <= 0 {
+		return diffuse, specular, 0
+	}
+	return diffuse, specular, s / (d + s)
+}
+
+func (g Glossy) scatter(ray *ray, intersec *hit, s Sampler) (bool, Color) {
+	diffuse, specular, probability := g.lobes(intersec)
+	if s.get1D() < probability {
+		b, attenuation := specular.scatter(ray, intersec, s)
+		return b, attenuation.Div(probability)
+	}
+	b, attenuation := diffuse.scatter(ray, intersec, s)
+	return b, attenuation.Div(1 - probability)
+}
+
+func (g Glossy) evaluate(in Vector3, out Vector3, h *hit) (Color, float64) {
+	diffuse, specular, probability := g.lobes(h)
+	fd, pdfD := diffuse.evaluate(in, out, h)
+	fs, pdfS := specular.evaluate(in, out, h)
+	return fd.Add(fs), (1-probability)*pdfD + probability*pdfS
+}
+
+func (Glossy) emittedLight() Color {
+	return NewColor(0, 0, 0)
+}
+
+func (g Glossy) surfaceAlbedo(h *hit) Color {
+	return albedo(g.Albedo, g.Texture, h)
+}
+
+// Rough glass using the GGX (Trowbridge-Reitz) microfacet distribution for reflection and transmission
+type Dielectric struct {
+	Albedo    Color
+	Texture   Texture // replaces Albedo if set
+	Ratio     float64 // index of refraction
+	Roughness float64 // perceptual roughness in range [0,1]
+}
+
+func (d Dielectric) scatter(ray *ray, intersec *hit, s Sampler) (bool, Color) {
+	frame := newShadingFrame(intersec.normal)
+	wo := frame.toLocal(ray.direction.Unit().Mul(-1))
+	eta := d.eta(intersec)
+	m := NewVector3(0, 0, 1)
+	alpha := d.Roughness * d.Roughness
+	specular := d.Roughness < SPECULAR_ROUGHNESS
+	u1, u2 := s.get2D()
+	if !specular {
+		m = sampleGGXVisibleNormal(wo, alpha, u1, u2)
+	}
+
+	var wi Vector3
+	if fresnelDielectric(wo.Dot(m), eta) > s.get1D() {
+		wi = reflect(wo.Mul(-1), m)
+		if wi.Z <= 0 {
+			return false, Color{}
+		}
+	} else {
+		wi = refract(wo.Mul(-1), m, 1/eta)
+		if wi.Z >= 0 {
+			return false, Color{}
+		}
+	}
+	ray.reuse(intersec.point, frame.toWorld(wi))
+	tint := albedo(d.Albedo, d.Texture, intersec)
+	if specular {
+		return true, tint
+	}
+	// Fresnel term cancels out with the probability of choosing reflection or transmission
+	return true, tint.Scale(smithG2(wo, wi, alpha) / smithG1(wo, alpha))
+}
+
+func (d Dielectric) evaluate(in Vector3, out Vector3, h *hit) (Color, float64) {
+	if d.Roughness < SPECULAR_ROUGHNESS {
+		return Color{}, 0
+	}
+	frame := newShadingFrame(h.normal)
+	wo := frame.toLocal(in.Unit().Mul(-1))
+	wi := frame.toLocal(out.Unit())
+	if wo.Z <= 0 || wi.Z == 0 {
+		return Color{}, 0
+	}
+	alpha := d.Roughness * d.Roughness
+	eta := d.eta(h)
+
+	if wi.Z > 0 {
+		// Reflection
+		m := wo.Add(wi).Unit()
+		fr := fresnelDielectric(wo.Dot(m), eta)
+		dm := ggxD(m, alpha)
+		f := albedo(d.Albedo, d.Texture, h).Scale(fr * dm * smithG2(wo, wi, alpha) / (4 * wo.Z))
+		pdf := fr * dm * smithG1(wo, alpha) / (4 * wo.Z)
+		return f, pdf
+	}
+
+	// Transmission, using the generalized half vector
+	m := wo.Add(wi.Mul(eta)).Unit()
+	if m.Z < 0 {
+		m = m.Mul(-1)
+	}
+	cosO := wo.Dot(m)
+	cosI := wi.Dot(m)
+	if cosO <= 0 || cosI >= 0 {
+		return Color{}, 0
+	}
+	fr := fresnelDielectric(cosO, eta)
+	dm := ggxD(m, alpha)
+	denom := cosO + eta*cosI
+	jacobian := eta * eta * math.Abs(cosI) / (denom * denom)
+	f := albedo(d.Albedo, d.Texture, h).Scale((1 - fr) * dm * smithG2(wo, wi, alpha) * cosO * jacobian / wo.Z)
+	pdf := (1 - fr) * dm * smithG1(wo, alpha) * cosO / wo.Z * jacobian
+	return f, pdf
+}
+
+// Relative index of refraction of the side the ray is entering
+func (d Dielectric) eta(h *hit) float64 {
+	if h.frontFace {
+		return d.Ratio
+	}
+	return 1 / d.Ratio
+}
+
+func (Dielectric) emittedLight() Color {
+	return NewColor(0, 0, 0)
+}
+
+func (d Dielectric) surfaceAlbedo(h *hit) Color {
+	return albedo(d.Albedo, d.Texture, h)
+}
+
+// Orthonormal basis around the shading normal, the normal becomes the z axis in local coordinates
+type shadingFrame struct {
+	u Vector3
+	v Vector3
+	n Vector3
+}
+
+func newShadingFrame(normal Vector3) shadingFrame {
+	n := normal.Unit()
+	u, v := orthonormalBasis(n)
+	return shadingFrame{
+		u: u,
+		v: v,
+		n: n,
+	}
+}
+
+func (f shadingFrame) toLocal(dir Vector3) Vector3 {
+	return NewVector3(dir.Dot(f.u), dir.Dot(f.v), dir.Dot(f.n))
+}
+
+func (f shadingFrame) toWorld(dir Vector3) Vector3 {
+	return f.u.Mul(dir.X).Add(f.v.Mul(dir.Y)).Add(f.n.Mul(dir.Z))
+}
+
+// GGX normal distribution function for microfacet normal m in local coordinates
+func ggxD(m Vector3, alpha float64) float64 {
+	if m.Z <= 0 {
+		return 0
+	}
+	a2 := alpha * alpha
+	cos2 := m.Z * m.Z
+	denom := cos2*(a2-1) + 1
+	return a2 / (math.Pi * denom * denom)
+}
+
+// Smith lambda function of the GGX distribution
+func ggxLambda(w Vector3, alpha float64) float64 {
+	cos2 := w.Z * w.Z
+	if cos2 == 0 {
+		return math.Inf(1)
+	}
+	tan2 := (1 - cos2) / cos2
+	return (math.Sqrt(1+alpha*alpha*tan2) - 1) / 2
+}
+
+// Smith masking function
+func smithG1(w Vector3, alpha float64) float64 {
+	return 1 / (1 + ggxLambda(w, alpha))
+}
+
+// Height correlated Smith masking-shadowing function
+func smithG2(wo Vector3, wi Vector3, alpha float64) float64 {
+	return 1 / (1 + ggxLambda(wo, alpha) + ggxLambda(wi, alpha))
+}
+
+// Samples the distribution of normals visible from wo (Heitz 2018), wo has to be in local coordinates
+func sampleGGXVisibleNormal(wo Vector3, alpha float64, u1, u2 float64) Vector3 {
+	// Transform view direction to the hemisphere configuration
+	vh := NewVector3(alpha*wo.X, alpha*wo.Y, wo.Z).Unit()
+	lenSquared := vh.X*vh.X + vh.Y*vh.Y
+	t1 := NewVector3(1, 0, 0)
+	if lenSquared > 0 {
+		t1 = NewVector3(-vh.Y, vh.X, 0).Mul(1 / math.Sqrt(lenSquared))
+	}
+	t2 := vh.Cross(t1)
+
+	// Sample the projected area
+	radius := math.Sqrt(u1)
+	phi := 2 * math.Pi * u2
+	p1 := radius * math.Cos(phi)
+	p2 := radius * math.Sin(phi)
+	s := 0.5 * (1 + vh.Z)
+	p2 = (1-s)*math.Sqrt(1-p1*p1) + s*p2
+
+	// Reproject onto the hemisphere and transform back to the ellipsoid configuration
+	nh := t1.Mul(p1).Add(t2.Mul(p2)).Add(vh.Mul(math.Sqrt(math.Max(0, 1-p1*p1-p2*p2))))
+	return NewVector3(alpha*nh.X, alpha*nh.Y, math.Max(1e-6, nh.Z)).Unit()
+}
+
+// Fresnel reflectance of a conductor with complex index of refraction eta + i*k
+func fresnelConductor(cos, eta, k float64) float64 {
+	cos = Clamp(cos, 0, 1)
+	cos2 := cos * cos
+	sin2 := 1 - cos2
+	eta2 := eta * eta
+	k2 := k * k
+
+	t0 := eta2 - k2 - sin2
+	a2b2 := math.Sqrt(t0*t0 + 4*eta2*k2)
+	t1 := a2b2 + cos2
+	a := math.Sqrt(math.Max(0, 0.5*(a2b2+t0)))
+	t2 := 2 * cos * a
+	rs := (t1 - t2) / (t1 + t2)
+
+	t3 := cos2*a2b2 + sin2*sin2
+	t4 := t2 * sin2
+	rp := rs * (t3 - t4) / (t3 + t4)
+	return 0.5 * (rp + rs)
+}
+
+// Unpolarized Fresnel reflectance of a dielectric, eta is the ratio of the transmitted to the incident index of refraction
+func fresnelDielectric(cos, eta float64) float64 {
+	cos = Clamp(cos, 0, 1)
+	sin2Transmitted := (1 - cos*cos) / (eta * eta)
+	if sin2Transmitted >= 1 {
+		// Total internal reflection
+		return 1
+	}
+	cosTransmitted := math.Sqrt(1 - sin2Transmitted)
+	rs := (cos - eta*cosTransmitted) / (cos + eta*cosTransmitted)
+	rp := (eta*cos - cosTransmitted) / (eta*cos + cosTransmitted)
+	return (rs*rs + rp*rp) / 2
+}
@@ -0,0 +1,100 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestGGXDistributionNormalized(t *testing.T) {
+	for _, alpha := range []float64{0.05, 0.2, 0.5, 1} {
+		// Projected area of the microfacets has to equal the area of the macro surface
+		const steps = 100000
+		sum := 0.0
+		for i := 0; i < steps; i++ {
+			theta := (float64(i) + 0.5) / steps * math.Pi / 2
+			m := NewVector3(math.Sin(theta), 0, math.Cos(theta))
+			sum += ggxD(m, alpha) * math.Cos(theta) * math.Sin(theta) * 2 * math.Pi * math.Pi / 2 / steps
+		}
+		if math.Abs(sum-1) > 1e-3 {
+			t.Errorf("alpha %v: projected microfacet area is %v", alpha, sum)
+		}
+	}
+}
+
+var microfacetTests = []struct {
+	name     string
+	material interface {
+		Material
+		bsdf
+	}
+	frontFace bool
+}{
+	{"conductor 0.3", Gold.WithRoughness(0.3), true},
+	{"conductor 0.7", Gold.WithRoughness(0.7), true},
+	{"dielectric 0.3", Dielectric{Albedo: NewColor(1, 1, 1), Ratio: 1.5, Roughness: 0.3}, true},
+	{"dielectric 0.7", Dielectric{Albedo: NewColor(1, 1, 1), Ratio: 1.5, Roughness: 0.7}, true},
+	{"dielectric inside", Dielectric{Albedo: NewColor(1, 1, 1), Ratio: 1.5, Roughness: 0.5}, false},
+}
+
+// Direction towards the surface with the given angle to the normal (0,0,1)
+func incidentDirection(theta float64) Vector3 {
+	return NewVector3(-math.Sin(theta), 0, -math.Cos(theta))
+}
+
+func TestMicrofacetScatterMatchesEvaluate(t *testing.T) {
+	sampler := NewIndependentSampler().clone(rand.New(rand.NewSource(1)), 1, 0)
+	for _, test := range microfacetTests {
+		for _, theta := range []float64{0, 0.5, 1.2} {
+			in := incidentDirection(theta)
+			for i := 0; i < 1000; i++ {
+				h := hit{normal: NewVector3(0, 0, 1), frontFace: test.frontFace}
+				r := newRay(NewVector3(0, 0, 0), in)
+				ok, attenuation := test.material.scatter(&r, &h, sampler)
+				if !ok {
+					continue
+				}
+				f, pdf := test.material.evaluate(in, r.direction, &h)
+				expected := f.Div(pdf)
+				if pdf <= 0 || Vector3(attenuation).Sub(Vector3(expected)).Length() > 1e-6*(1+Vector3(expected).Length()) {
+					t.Fatalf("%v at %v: scattered to %v with weight %v, evaluated %v with pdf %v", test.name, theta, r.direction, attenuation, f, pdf)
+				}
+			}
+		}
+	}
+}
+
+func TestMicrofacetPdfIntegratesToScatterProbability(t *testing.T) {
+	const samples = 100000
+	const steps = 1000
+	sampler := NewIndependentSampler().clone(rand.New(rand.NewSource(1)), 1, 0)
+	for _, test := range microfacetTests {
+		for _, theta := range []float64{0.4, 0.8, 1.2} {
+			in := incidentDirection(theta)
+			h := hit{normal: NewVector3(0, 0, 1), frontFace: test.frontFace}
+			// Midpoint rule over the sphere, which has a uniform area element in z and phi.
+			// Normal incidence is left out, the grid is too coarse for the narrow lobes around the poles
+			integral := 0.0
+			for i := 0; i < steps; i++ {
+				z := -1 + (float64(i)+0.5)/steps*2
+				radius := math.Sqrt(1 - z*z)
+				for j := 0; j < steps; j++ {
+					phi := (float64(j) + 0.5) / steps * 2 * math.Pi
+					_, pdf := test.material.evaluate(in, NewVector3(radius*math.Cos(phi), radius*math.Sin(phi), z), &h)
+					integral += pdf * 4 * math.Pi / (steps * steps)
+				}
+			}
+			scattered := 0
+			for i := 0; i < samples; i++ {
+				r := newRay(NewVector3(0, 0, 0), in)
+				if ok, _ := test.material.scatter(&r, &h, sampler); ok {
+					scattered++
+				}
+			}
+			expected := float64(scattered) / samples
+			if math.Abs(integral-expected) > 0.01 {
+				t.Errorf("%v at %v: pdf integrates to %v, scatter succeeds with probability %v", test.name, theta, integral, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package pt
+
+// Mesh moving during the shutter interval. The transformation is interpolated linearly between start and end at the time of each ray,
+// so every point of the mesh moves on a straight line and the union of the bounds at start and end encloses the whole motion
+type movingInstance struct {
+	blas  *BVH
+	start Matrix4 // object to world space at time 0
+	end   Matrix4 // object to world space at time 1
+	box   aabb    // world space bounding box of the swept motion
+}
+
+func newMovingInstance(blas *BVH, start, end Matrix4) *movingInstance {
+	return &movingInstance{
+		blas:  blas,
+		start: start,
+		end:   end,
+		box:   transformedAABB(blas.root.bounding, start).add(transformedAABB(blas.root.bounding, end)),
+	}
+}
+
+func (i *movingInstance) intersected(r ray, tMin, tMax float64, hitOut *hit) bool {
+	inverse := i.start.lerp(i.end, r.time).Inverse()
+	return intersectedTransformed(i.blas, r, inverse, inverse.Transpose(), tMin, tMax, hitOut)
+}
+
+func (i *movingInstance) occluded(r ray, tMin, tMax float64) bool {
+	inverse := i.start.lerp(i.end, r.time).Inverse()
+	return i.blas.occluded(objectSpaceRay(r, inverse), tMin, tMax)
+}
+
+func (i *movingInstance) bounding() aabb {
+	return i.box
+}
+
+func (i *movingInstance) transformed(t Matrix4) primitive {
+	return newMovingInstance(i.blas, t.MultiplyMatrix(i.start), t.MultiplyMatrix(i.end))
+}
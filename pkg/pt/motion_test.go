@@ -0,0 +1,98 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMotion(t *testing.T) {
+	geometry := Geometry{
+		NewTriangleWithoutNormals(NewVector3(-1, -1, 0), NewVector3(1, -1, 0), NewVector3(0, 1, 0)),
+		NewSphere(NewVector3(0, 0, -1), 0.5),
+	}
+	tests := []struct {
+		name  string
+		start Matrix4
+		end   Matrix4
+		// Transformation of the static node at the given time
+		at func(time float64) Matrix4
+	}{
+		{"translation", IdentityMatrix(), Translate(3, 1, 0), func(time float64) Matrix4 { return Translate(3*time, time, 0) }},
+		{"moved translation", Translate(-1, 0, 0), Translate(1, 0, 0), func(time float64) Matrix4 { return Translate(2*time-1, 0, 0) }},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		mesh := NewMesh(geometry, Diffuse{})
+		moving := NewSceneNode(mesh)
+		moving.SetTransformation(test.start)
+		moving.SetMotion(test.end)
+		scene := NewScene()
+		scene.Add(moving)
+		bvh := scene.Compile()
+		for _, time := range []float64{0, 0.25, 0.5, 1} {
+			static := NewSceneNode(mesh)
+			static.SetTransformation(test.at(time))
+			staticScene := NewScene()
+			staticScene.Add(static)
+			reference := staticScene.Compile()
+			for i := 0; i < 500; i++ {
+				ray := newRay(NewVector3(r.Float64()*8-3, r.Float64()*4-2, 5), NewVector3(0, 0, -1))
+				ray.time = time
+				expected, got := hit{}, hit{}
+				expectedOk := reference.intersected(ray, 0.0001, math.Inf(1), &expected)
+				ok := bvh.intersected(ray, 0.0001, math.Inf(1), &got)
+				if ok != expectedOk || math.Abs(got.t-expected.t) > 1e-9 || got.index != expected.index || (ok && got.node != moving) {
+					t.Fatalf("%v at %v: ray %v hit %v at %v, expected %v at %v", test.name, time, i, ok, got.t, expectedOk, expected.t)
+				}
+				if ok && got.normal.Sub(expected.normal).Length() > 1e-9 {
+					t.Fatalf("%v at %v: normal %v, expected %v", test.name, time, got.normal, expected.normal)
+				}
+			}
+		}
+	}
+}
+
+func TestMotionOfParent(t *testing.T) {
+	// Children inherit the motion of their parents
+	parent := NewSceneNode(nil)
+	parent.SetMotion(Translate(0, 2, 0))
+	child := NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 0.5)}, Diffuse{}))
+	child.Translate(1, 0, 0)
+	parent.Add(child)
+	scene := NewScene()
+	scene.Add(parent)
+	bvh := scene.Compile()
+	for _, time := range []float64{0, 0.5, 1} {
+		ray := newRay(NewVector3(1, 2*time, 5), NewVector3(0, 0, -1))
+		ray.time = time
+		h := hit{}
+		if !bvh.intersected(ray, 0.0001, math.Inf(1), &h) || math.Abs(h.t-4.5) > 1e-9 || h.node != child {
+			t.Errorf("sphere was not hit at its position at time %v", time)
+		}
+	}
+}
+
+func TestShutter(t *testing.T) {
+	cam := NewCamera(1, 60, CameraTransformation{NewVector3(0, 0, 0), NewVector3(0, 0, -1), NewVector3(0, 1, 0)})
+	sampler := NewIndependentSampler().clone(rand.New(rand.NewSource(1)), 1, 0)
+	r := ray{}
+	for i := 0; i < 100; i++ {
+		cam.castRay(0.5, 0.5, sampler, &r)
+		if r.time != 0 {
+			t.Fatalf("ray at time %v without a shutter interval", r.time)
+		}
+	}
+	cam.SetShutter(0.25, 0.75)
+	mean := 0.0
+	for i := 0; i < 1000; i++ {
+		cam.castRay(0.5, 0.5, sampler, &r)
+		if r.time < 0.25 || r.time > 0.75 {
+			t.Fatalf("ray at time %v outside of the shutter interval", r.time)
+		}
+		mean += r.time / 1000
+	}
+	if math.Abs(mean-0.5) > 0.02 {
+		t.Errorf("mean ray time is %v", mean)
+	}
+}
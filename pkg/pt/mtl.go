@@ -0,0 +1,185 @@
+package pt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Material as defined in a .mtl material library
+type mtlMaterial struct {
+	diffuse      Color   // Kd
+	specular     Color   // Ks
+	emission     Color   // Ke
+	shininess    float64 // Ns, specular exponent in range [0,1000]
+	ior          float64 // Ni
+	dissolve     float64 // d, 1 is fully opaque
+	illumination int     // illum
+	diffuseMap   string  // map_Kd, path of the diffuse texture
+}
+
+func newMTLMaterial() mtlMaterial {
+	return mtlMaterial{
+		diffuse:      NewColor(.8, .8, .8),
+		ior:          1,
+		dissolve:     1,
+		illumination: 2,
+	}
+}
+
+// Maps the .mtl parameters onto the closest material of this package.
+// Emissive materials become lights and transparent ones dielectrics. Mirrors (illum 3, 5 and 8) become conductors reflecting Ks,
+// other materials with a specular color Glossy materials, both with a roughness derived from Ns. Ambient colors and specular maps are ignored.
+// Textures are loaded once and shared between materials through the textures cache
+func (m mtlMaterial) toMaterial(textures map[string]Texture) (Material, error) {
+	if !m.emission.isBlack() {
+		return Light{Color: m.emission}, nil
+	}
+	// Mirrors and transparent materials without specular exponent are assumed to be smooth
+	roughness := 0.0
+	if m.shininess > 0 {
+		roughness = m.roughness()
+	}
+	transparent := m.dissolve < 1 || m.illumination == 4 || m.illumination == 6 || m.illumination == 7 || m.illumination == 9
+	if transparent {
+		return Dielectric{Albedo: NewColor(1, 1, 1), Ratio: m.ior, Roughness: roughness}, nil
+	}
+	mirror := m.illumination == 3 || m.illumination == 5 || m.illumination == 8
+	if mirror && !m.specular.isBlack() {
+		return reflectanceConductor(m.specular, roughness), nil
+	}
+	var texture Texture
+	if m.diffuseMap != "" {
+		cached, ok := textures[m.diffuseMap]
+		if !ok {
+			tex, err := LoadImageTextureFromPath(m.diffuseMap, WrapRepeat)
+			if err != nil {
+				return nil, err
+			}
+			cached = tex
+			textures[m.diffuseMap] = cached
+		}
+		texture = cached
+	}
+	// Illumination models below 2 have no specular highlight
+	if m.illumination >= 2 && !m.specular.isBlack() {
+		return Glossy{Albedo: m.diffuse, Texture: texture, Specular: m.specular, Roughness: m.roughness()}, nil
+	}
+	return Diffuse{Albedo: m.diffuse, Texture: texture}, nil
+}
+
+// Converts the Phong exponent into a perceptual roughness in range [0,1]
+func (m mtlMaterial) roughness() float64 {
+	alpha := math.Sqrt(2 / (m.shininess + 2))
+	return math.Sqrt(alpha)
+}
+
+func loadMTLFromPath(path string) (map[string]mtlMaterial, error) {
+	mtlFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer mtlFile.Close()
+	return parseMTL(mtlFile, path)
+}
+
+// Texture paths are resolved relative to the directory of file
+func parseMTL(reader io.Reader, file string) (map[string]mtlMaterial, error) {
+	scanner := bufio.NewScanner(reader)
+	dir := filepath.Dir(file)
+	materials := make(map[string]mtlMaterial)
+	name := ""
+	current := newMTLMaterial()
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		key := fields[0]
+		values := fields[1:]
+
+		var token string
+		var err error
+		switch key {
+		case "newmtl":
+			if name != "" {
+				materials[name] = current
+			}
+			name = strings.Join(values, " ")
+			current = newMTLMaterial()
+		case "Kd":
+			current.diffuse, token, err = parseMTLColor(values)
+		case "Ks":
+			current.specular, token, err = parseMTLColor(values)
+		case "Ke":
+			current.emission, token, err = parseMTLColor(values)
+		case "Ns":
+			current.shininess, token, err = parseMTLFloat(values)
+		case "Ni":
+			current.ior, token, err = parseMTLFloat(values)
+		case "d":
+			current.dissolve, token, err = parseMTLFloat(values)
+		case "Tr":
+			var transparency float64
+			transparency, token, err = parseMTLFloat(values)
+			current.dissolve = 1 - transparency
+		case "map_Kd":
+			if len(values) == 0 {
+				token, err = line, ErrMissingValue
+				break
+			}
+			// Texture options precede the file name
+			current.diffuseMap = filepath.Join(dir, filepath.FromSlash(values[len(values)-1]))
+		case "illum":
+			if len(values) == 0 {
+				token, err = line, ErrMissingValue
+				break
+			}
+			current.illumination, err = strconv.Atoi(values[0])
+			token = values[0]
+		}
+		if err != nil {
+			return nil, &ParseError{File: file, Line: lineNumber, Token: token, Err: err}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+	if name != "" {
+		materials[name] = current
+	}
+	return materials, nil
+}
+
+// Colors are given as either one gray value or three rgb values
+func parseMTLColor(values []string) (Color, string, error) {
+	numbers, token, err := parseFloatN(values, 1)
+	if err != nil {
+		return Color{}, token, err
+	}
+	if len(numbers) < 3 {
+		return NewColor(numbers[0], numbers[0], numbers[0]), "", nil
+	}
+	return NewColor(numbers[0], numbers[1], numbers[2]), "", nil
+}
+
+func parseMTLFloat(values []string) (float64, string, error) {
+	if len(values) == 0 {
+		return 0, "", ErrMissingValue
+	}
+	numbers, token, err := parseFloatN(values[:1], 1)
+	if err != nil {
+		return 0, token, err
+	}
+	return numbers[0], "", nil
+}
@@ -0,0 +1,118 @@
+package pt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMTLToMaterial(t *testing.T) {
+	tests := []struct {
+		name     string
+		mtl      string
+		expected Material
+	}{
+		{"default", "newmtl a\n", Diffuse{Albedo: NewColor(.8, .8, .8)}},
+		{"gray diffuse", "newmtl a\nKd 0.5\nKs 0 0 0\n", Diffuse{Albedo: NewColor(.5, .5, .5)}},
+		{"no highlight", "newmtl a\nKd 0.5 0.2 0.1\nKs 0.3 0.3 0.3\nillum 1\n", Diffuse{Albedo: NewColor(.5, .2, .1)}},
+		{"light", "newmtl a\nKd 0.5 0.5 0.5\nKe 4 4 2\n", Light{Color: NewColor(4, 4, 2)}},
+		{"glass", "newmtl a\nNi 1.5\nd 0.5\n", Dielectric{Albedo: NewColor(1, 1, 1), Ratio: 1.5}},
+		{"transparency", "newmtl a\nNi 1.3\nTr 0.8\n", Dielectric{Albedo: NewColor(1, 1, 1), Ratio: 1.3}},
+		{"refraction illum", "newmtl a\nNi 1.3\nillum 7\n", Dielectric{Albedo: NewColor(1, 1, 1), Ratio: 1.3}},
+		{"mirror", "newmtl a\nKs 0.8 0.8 0.8\nillum 3\n", reflectanceConductor(NewColor(.8, .8, .8), 0)},
+		{"rough mirror", "newmtl a\nKs 0.8 0.8 0.8\nNs 98\nillum 5\n", reflectanceConductor(NewColor(.8, .8, .8), mtlMaterial{shininess: 98}.roughness())},
+		{"phong", "newmtl a\nKd 0.5 0.2 0.2\nKs 0.3 0.3 0.3\nNs 98\n", Glossy{Albedo: NewColor(.5, .2, .2), Specular: NewColor(.3, .3, .3), Roughness: mtlMaterial{shininess: 98}.roughness()}},
+	}
+	for _, test := range tests {
+		materials, err := parseMTL(strings.NewReader(test.mtl), "test.mtl")
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		mat, err := materials["a"].toMaterial(map[string]Texture{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if mat != test.expected {
+			t.Errorf("%v: got %#v, expected %#v", test.name, mat, test.expected)
+		}
+	}
+}
+
+func TestMTLRoughness(t *testing.T) {
+	previous := 2.0
+	for _, shininess := range []float64{0, 1, 10, 100, 1000} {
+		roughness := mtlMaterial{shininess: shininess}.roughness()
+		if roughness <= 0 || roughness > 1 || roughness >= previous {
+			t.Errorf("Ns %v: roughness %v is not in (0,1] or not below %v", shininess, roughness, previous)
+		}
+		previous = roughness
+	}
+}
+
+func TestParseMTLNamesAndTextures(t *testing.T) {
+	mtl := "newmtl first material\nKd 1 0 0\n\nnewmtl second\nmap_Kd -bm 1 textures/wood.png\n"
+	materials, err := parseMTL(strings.NewReader(mtl), filepath.Join("models", "test.mtl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(materials) != 2 {
+		t.Fatalf("parsed %v materials, expected 2", len(materials))
+	}
+	if materials["first material"].diffuse != NewColor(1, 0, 0) {
+		t.Errorf("diffuse color of the first material is %v", materials["first material"].diffuse)
+	}
+	if expected := filepath.Join("models", "textures", "wood.png"); materials["second"].diffuseMap != expected {
+		t.Errorf("texture path is %v, expected %v", materials["second"].diffuseMap, expected)
+	}
+}
+
+func TestLoadOBJSceneFromPath(t *testing.T) {
+	dir := t.TempDir()
+	obj := `mtllib scene.mtl
+mtllib missing.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+v 1 1 0
+f 1 2 3
+usemtl red
+f 2 4 3
+usemtl unknown
+f 1 2 4
+usemtl red
+f 1 3 4
+usemtl unused
+`
+	files := map[string]string{
+		"scene.obj": obj,
+		"scene.mtl": "newmtl red\nKd 1 0 0\nKs 0\nnewmtl unused\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fallback := &Diffuse{Albedo: NewColor(0, 1, 0)}
+	node, err := LoadOBJSceneFromPath(filepath.Join(dir, "scene.obj"), fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		material  Material
+		triangles int
+	}{
+		{fallback, 1},
+		{Diffuse{Albedo: NewColor(1, 0, 0)}, 2},
+		{fallback, 1},
+	}
+	if len(node.children) != len(expected) {
+		t.Fatalf("got %v meshes, expected %v", len(node.children), len(expected))
+	}
+	for i, e := range expected {
+		mesh := node.children[i].mesh
+		if mesh.material != e.material || len(mesh.geometry) != e.triangles {
+			t.Errorf("mesh %v has %v triangles of %#v, expected %v of %#v", i, len(mesh.geometry), mesh.material, e.triangles, e.material)
+		}
+	}
+}
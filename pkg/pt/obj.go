@@ -2,28 +2,166 @@ package pt
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+var (
+	ErrMissingValue      = errors.New("missing value")
+	ErrIndexOutOfRange   = errors.New("index out of range")
+	ErrDegeneratePolygon = errors.New("face needs at least 3 vertecies")
+)
+
+// ParseError describes a malformed line of an .obj or .mtl file
+type ParseError struct {
+	File  string // empty if the input was not read from a file
+	Line  int
+	Token string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	file := e.File
+	if file == "" {
+		file = "<input>"
+	}
+	return fmt.Sprintf("%v:%v: invalid token %q: %v", file, e.Line, e.Token, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parses an .obj file into a single geometry, panics on error. Use LoadOBJFromPath to handle errors
 func ParseFromPath(path string) Geometry {
-	objFile, err := os.Open(path)
+	geometry, err := LoadOBJFromPath(path)
 	if err != nil {
 		panic(err)
 	}
+	return geometry
+}
+
+// Parses an .obj file including its .mtl material libraries, panics on error. Use LoadOBJSceneFromPath to handle errors
+func ParseSceneFromPath(path string, fallback Material) *SceneNode {
+	node, err := LoadOBJSceneFromPath(path, fallback)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// Parses .obj data into a single geometry, materials referenced by the data are ignored
+func LoadOBJ(reader io.Reader) (Geometry, error) {
+	obj, err := parseOBJ(reader, "")
+	if err != nil {
+		return nil, err
+	}
+	return obj.geometry(), nil
+}
+
+// Parses an .obj file into a single geometry, materials referenced by the file are ignored
+func LoadOBJFromPath(path string) (Geometry, error) {
+	objFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer objFile.Close()
+	obj, err := parseOBJ(objFile, path)
+	if err != nil {
+		return nil, err
+	}
+	return obj.geometry(), nil
+}
+
+// Parses an .obj file including its .mtl material libraries.
+// Returns a node with one child mesh per material, faces without a known material use fallback.
+// Material libraries that can not be read are logged and skipped, malformed libraries return an error
+func LoadOBJSceneFromPath(path string, fallback Material) (*SceneNode, error) {
+	objFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 	defer objFile.Close()
-	return parseOBJ(objFile)
+	obj, err := parseOBJ(objFile, path)
+	if err != nil {
+		return nil, err
+	}
+
+	materials := make(map[string]mtlMaterial)
+	for _, library := range obj.libraries {
+		lib, err := loadMTLFromPath(filepath.Join(filepath.Dir(path), library))
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("%v: skipping material library: %v", path, err)
+			continue
+		}
+		for name, mat := range lib {
+			materials[name] = mat
+		}
+	}
+
+	root := NewSceneNode(nil)
+	textures := make(map[string]Texture)
+	for _, group := range obj.groups {
+		var mat Material = fallback
+		if m, ok := materials[group.material]; ok {
+			if mat, err = m.toMaterial(textures); err != nil {
+				return nil, err
+			}
+		}
+		root.Add(NewSceneNode(NewMesh(group.geometry, mat)))
+	}
+	return root, nil
 }
 
-func parseOBJ(objFile *os.File) []primitive {
-	scanner := bufio.NewScanner(objFile)
+// Content of an .obj file, faces are grouped by the material they use
+type objFile struct {
+	groups    []*objGroup
+	libraries []string
+}
+
+type objGroup struct {
+	material string
+	geometry Geometry
+}
+
+// Geometry of all groups combined
+func (obj objFile) geometry() Geometry {
+	geometry := make(Geometry, 0)
+	for _, group := range obj.groups {
+		geometry = append(geometry, group.geometry...)
+	}
+	return geometry
+}
+
+// file is only used for error messages
+func parseOBJ(reader io.Reader, file string) (objFile, error) {
+	scanner := bufio.NewScanner(reader)
 
 	vertecies := make([]Vector3, 1, 1024)
 	normals := make([]Vector3, 1, 1024)
-	triangles := make([]primitive, 0, 1024)
+	texCoords := make([]texCoord, 1, 1024)
 
+	obj := objFile{}
+	groupIndex := make(map[string]*objGroup)
+	current := &objGroup{
+		geometry: make(Geometry, 0, 1024),
+	}
+	groupIndex[""] = current
+	obj.groups = append(obj.groups, current)
+
+	lineNumber := 0
 	for scanner.Scan() {
+		lineNumber++
 		line := scanner.Text()
 		fields := strings.Fields(line)
 		if len(fields) == 0 {
@@ -31,110 +169,191 @@ func parseOBJ(objFile *os.File) []primitive {
 		}
 		key := fields[0]
 		values := fields[1:]
+		fail := func(token string, err error) (objFile, error) {
+			return objFile{}, &ParseError{File: file, Line: lineNumber, Token: token, Err: err}
+		}
 
 		switch key {
 		case "v":
-			if numbers, err := parseFloat(values); err != nil {
-				panic(err)
-			} else {
-				vertecies = append(vertecies, NewVector3(numbers[0], numbers[1], numbers[2]))
+			numbers, token, err := parseFloatN(values, 3)
+			if err != nil {
+				return fail(token, err)
 			}
+			vertecies = append(vertecies, NewVector3(numbers[0], numbers[1], numbers[2]))
 		case "vt":
+			// The v coordinate is optional
+			numbers, token, err := parseFloatN(values, 1)
+			if err != nil {
+				return fail(token, err)
+			}
+			coord := texCoord{u: numbers[0]}
+			if len(numbers) >= 2 {
+				coord.v = numbers[1]
+			}
+			texCoords = append(texCoords, coord)
 		case "vn":
-			if numbers, err := parseFloat(values); err != nil {
-				panic(err)
-			} else {
-				normals = append(normals, NewVector3(numbers[0], numbers[1], numbers[2]))
+			numbers, token, err := parseFloatN(values, 3)
+			if err != nil {
+				return fail(token, err)
 			}
+			normals = append(normals, NewVector3(numbers[0], numbers[1], numbers[2]))
 		case "f":
-			if face, err := parseFace(values, vertecies, normals); err != nil {
-				panic(err)
-			} else {
-				triangles = append(triangles, face...)
+			face, token, err := parseFace(values, vertecies, texCoords, normals)
+			if err != nil {
+				if token == "" {
+					token = line
+				}
+				return fail(token, err)
 			}
+			current.geometry = append(current.geometry, face...)
+		case "mtllib":
+			if len(values) == 0 {
+				return fail(line, ErrMissingValue)
+			}
+			obj.libraries = append(obj.libraries, values...)
+		case "usemtl":
+			name := strings.Join(values, " ")
+			group, ok := groupIndex[name]
+			if !ok {
+				group = &objGroup{
+					material: name,
+				}
+				groupIndex[name] = group
+				obj.groups = append(obj.groups, group)
+			}
+			current = group
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		panic(err)
+		if file != "" {
+			return objFile{}, fmt.Errorf("%v: %w", file, err)
+		}
+		return objFile{}, err
 	}
 
-	//fmt.Printf("Tris: %v\n", len(triangles))
-
-	return triangles
+	// Drop groups without any faces
+	groups := make([]*objGroup, 0, len(obj.groups))
+	for _, group := range obj.groups {
+		if len(group.geometry) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	obj.groups = groups
+	return obj, nil
 }
 
-func parseFace(args []string, vertecies []Vector3, normals []Vector3) ([]primitive, error) {
+// Returns the triangulated face, or the offending token and an error
+func parseFace(args []string, vertecies []Vector3, texCoords []texCoord, normals []Vector3) ([]primitive, string, error) {
+	if len(args) < 3 {
+		return nil, "", ErrDegeneratePolygon
+	}
 	vIndeces := make([]int, 0, 4)
+	tIndeces := make([]int, 0, 4)
 	nIndeces := make([]int, 0, 4)
 	for _, arg := range args {
 		indeces := strings.Split(arg, "/")
-		vIndex, err := strconv.Atoi(indeces[0])
+		vIndex, err := parseIndex(indeces[0], len(vertecies))
 		if err != nil {
-			return nil, err
-		}
-		if vIndex < 0 {
-			vIndex = len(vertecies) + vIndex
+			return nil, arg, err
 		}
 		vIndeces = append(vIndeces, vIndex)
 
-		if len(indeces) >= 3 {
-			nIndex, err := strconv.Atoi(indeces[2])
+		if len(indeces) >= 2 && indeces[1] != "" {
+			tIndex, err := parseIndex(indeces[1], len(texCoords))
 			if err != nil {
-				return nil, err
+				return nil, arg, err
 			}
-			if nIndex < 0 {
-				nIndex = len(normals) + nIndex
+			tIndeces = append(tIndeces, tIndex)
+		}
+
+		if len(indeces) >= 3 {
+			nIndex, err := parseIndex(indeces[2], len(normals))
+			if err != nil {
+				return nil, arg, err
 			}
 			nIndeces = append(nIndeces, nIndex)
 		}
 	}
 
-	if len(nIndeces) == len(args) {
-		triangles := make([]primitive, 0)
-		for i := 1; i+2 <= len(vIndeces); i++ {
-			triangles = append(triangles, triangleForIndeces(append(vIndeces[0:1], vIndeces[i:i+2]...), append(nIndeces[0:1], nIndeces[i:i+2]...), vertecies, normals))
-		}
-		return triangles, nil
-	} else {
-		triangles := make([]primitive, 0)
-		for i := 1; i+2 <= len(vIndeces); i++ {
-			triangles = append(triangles, triangleWithoutNormals(append(vIndeces[0:1], vIndeces[i:i+2]...), vertecies))
+	// Texture coordinates and normals are only used if every vertex of the face specifies them
+	if len(tIndeces) != len(vIndeces) {
+		tIndeces = nil
+	}
+
+	// Polygons are triangulated as a fan around the first vertex
+	triangles := make([]primitive, 0, len(vIndeces)-2)
+	for i := 1; i+2 <= len(vIndeces); i++ {
+		v := fanIndeces(vIndeces, i)
+		t := fanIndeces(tIndeces, i)
+		if len(nIndeces) == len(vIndeces) {
+			triangles = append(triangles, triangleForIndeces(v, t, fanIndeces(nIndeces, i), vertecies, texCoords, normals))
+		} else {
+			triangles = append(triangles, triangleWithoutNormals(v, t, vertecies, texCoords))
 		}
-		return triangles, nil
 	}
+	return triangles, "", nil
 }
 
-func triangleWithoutNormals(vIndeces []int, vertecies []Vector3) *Triangle {
-	return NewTriangleWithoutNormals(vertecies[vIndeces[0]], vertecies[vIndeces[1]], vertecies[vIndeces[2]])
+// Parses a one based, possibly negative (relative) index into a list with a placeholder at index 0 and length n
+func parseIndex(token string, n int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 {
+		index = n + index
+	}
+	if index <= 0 || index >= n {
+		return 0, ErrIndexOutOfRange
+	}
+	return index, nil
 }
 
-func triangleForIndeces(vIndeces []int, nIndeces []int, vertecies []Vector3, normals []Vector3) *Triangle {
-	//return newTriangleWithoutNormals(vertecies[vIndeces[0]], vertecies[vIndeces[1]], vertecies[vIndeces[2]])
-	var v [3]vertex
-	v[0] = vertex{
-		position: vertecies[vIndeces[0]],
-		normal:   normals[nIndeces[0]],
+// Indeces of the i-th triangle of a triangle fan, nil if the face does not specify the indeces
+func fanIndeces(indeces []int, i int) []int {
+	if len(indeces) <= i+1 {
+		return nil
 	}
-	v[1] = vertex{
-		position: vertecies[vIndeces[1]],
-		normal:   normals[nIndeces[1]],
+	return []int{indeces[0], indeces[i], indeces[i+1]}
+}
+
+func triangleWithoutNormals(vIndeces []int, tIndeces []int, vertecies []Vector3, texCoords []texCoord) *Triangle {
+	tri := NewTriangleWithoutNormals(vertecies[vIndeces[0]], vertecies[vIndeces[1]], vertecies[vIndeces[2]])
+	if tIndeces != nil {
+		tri.vertecies[0].uv = texCoords[tIndeces[0]]
+		tri.vertecies[1].uv = texCoords[tIndeces[1]]
+		tri.vertecies[2].uv = texCoords[tIndeces[2]]
 	}
-	v[2] = vertex{
-		position: vertecies[vIndeces[2]],
-		normal:   normals[nIndeces[2]],
+	return tri
+}
+
+func triangleForIndeces(vIndeces []int, tIndeces []int, nIndeces []int, vertecies []Vector3, texCoords []texCoord, normals []Vector3) *Triangle {
+	var v [3]vertex
+	for i := 0; i < 3; i++ {
+		v[i] = vertex{
+			position: vertecies[vIndeces[i]],
+			normal:   normals[nIndeces[i]],
+		}
+		if tIndeces != nil {
+			v[i].uv = texCoords[tIndeces[i]]
+		}
 	}
 	return NewTriangle(v)
 }
 
-func parseFloat(args []string) ([]float64, error) {
+// Parses at least n floats, returns the offending token on error
+func parseFloatN(args []string, n int) ([]float64, string, error) {
+	if len(args) < n {
+		return nil, strings.Join(args, " "), ErrMissingValue
+	}
 	result := make([]float64, 0, len(args))
 	for _, arg := range args {
-		if num, err := strconv.ParseFloat(arg, 64); err != nil {
-			return nil, err
-		} else {
-			result = append(result, num)
+		num, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, arg, err
 		}
+		result = append(result, num)
 	}
-	return result, nil
+	return result, "", nil
 }
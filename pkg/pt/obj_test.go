@@ -0,0 +1,118 @@
+package pt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLoadOBJ(t *testing.T) {
+	obj := `# quad and triangle
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vn 0 0 1
+g ignored
+f 1//1 2//1 3//1 4//1
+f -4 -3 -1
+`
+	geometry, err := LoadOBJ(strings.NewReader(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(geometry) != 3 {
+		t.Fatalf("got %v triangles, expected 3", len(geometry))
+	}
+	last := geometry[2].(*Triangle)
+	if last.vertecies[2].position != NewVector3(0, 1, 0) {
+		t.Errorf("relative index resolved to %v", last.vertecies[2].position)
+	}
+}
+
+func TestLoadOBJErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		obj   string
+		line  int
+		token string
+		err   error
+	}{
+		{"missing coordinate", "v 0 0 0\nv 1 0\n", 2, "1 0", ErrMissingValue},
+		{"malformed number", "v 0 0 0\n\nv 1 x 0\n", 3, "x", strconv.ErrSyntax},
+		{"malformed normal", "vn 0 0 1e\n", 1, "1e", strconv.ErrSyntax},
+		{"vertex out of range", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4\n", 4, "4", ErrIndexOutOfRange},
+		{"zero index", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 0 1 2\n", 4, "0", ErrIndexOutOfRange},
+		{"relative index out of range", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf -1 -2 -4\n", 4, "-4", ErrIndexOutOfRange},
+		{"texture index out of range", "v 0 0 0\nv 1 0 0\nv 0 1 0\nvt 0 0\nf 1/1 2/2 3/1\n", 5, "2/2", ErrIndexOutOfRange},
+		{"normal index out of range", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1//1 2//1 3//1\n", 4, "1//1", ErrIndexOutOfRange},
+		{"degenerate face", "v 0 0 0\nv 1 0 0\nf 1 2\n", 3, "f 1 2", ErrDegeneratePolygon},
+		{"empty mtllib", "mtllib\n", 1, "mtllib", ErrMissingValue},
+	}
+	for _, test := range tests {
+		_, err := LoadOBJ(strings.NewReader(test.obj))
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%v: expected a ParseError, got %v", test.name, err)
+			continue
+		}
+		if parseErr.Line != test.line || parseErr.Token != test.token || !errors.Is(err, test.err) {
+			t.Errorf("%v: got %v, expected line %v, token %q and %v", test.name, err, test.line, test.token, test.err)
+		}
+	}
+}
+
+func TestParseMTLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		mtl   string
+		line  int
+		token string
+		err   error
+	}{
+		{"malformed color", "newmtl a\nKd 1 y 0\n", 2, "y", strconv.ErrSyntax},
+		{"missing color", "newmtl a\nKs\n", 2, "", ErrMissingValue},
+		{"missing float", "newmtl a\n\nNs\n", 3, "", ErrMissingValue},
+		{"malformed illum", "newmtl a\nillum two\n", 2, "two", strconv.ErrSyntax},
+		{"missing texture", "newmtl a\nmap_Kd\n", 2, "map_Kd", ErrMissingValue},
+	}
+	for _, test := range tests {
+		_, err := parseMTL(strings.NewReader(test.mtl), "test.mtl")
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%v: expected a ParseError, got %v", test.name, err)
+			continue
+		}
+		if parseErr.File != "test.mtl" || parseErr.Line != test.line || parseErr.Token != test.token || !errors.Is(err, test.err) {
+			t.Errorf("%v: got %v, expected line %v, token %q and %v", test.name, err, test.line, test.token, test.err)
+		}
+	}
+}
+
+func TestLoadOBJSceneFromPathMalformedMTL(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"scene.obj": "mtllib scene.mtl\nv 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+		"scene.mtl": "newmtl a\nKd 1 1\nNi x\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := LoadOBJSceneFromPath(filepath.Join(dir, "scene.obj"), Diffuse{})
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.File != filepath.Join(dir, "scene.mtl") || parseErr.Line != 3 {
+		t.Errorf("expected a ParseError in line 3 of the material library, got %v", err)
+	}
+}
+
+func TestLoadOBJFromPathMissing(t *testing.T) {
+	_, err := LoadOBJFromPath(filepath.Join(t.TempDir(), "missing.obj"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v, got %v", os.ErrNotExist, err)
+	}
+}
@@ -5,11 +5,17 @@ import (
 )
 
 type hit struct {
-	point     Vector3  // intersection Point
-	normal    Vector3  // normal at the intersection Point always pointing agains the ray
-	frontFace bool     // Wheter or not the ray hit from the outside or the inside
-	t         float64  // distance along the intersection ray
-	material  Material // Material at intersection point
+	point     Vector3   // intersection Point
+	normal    Vector3   // normal at the intersection Point always pointing agains the ray
+	frontFace bool      // Wheter or not the ray hit from the outside or the inside
+	t         float64   // distance along the intersection ray
+	material  Material  // Material at intersection point
+	prim      primitive // Primitive that was hit, used to evaluate the light pdf for multiple importance sampling
+	uv        texCoord  // Texture coordinates at the intersection point
+	u, v      float64   // barycentric coordinates of triangle hits
+	node      *SceneNode
+	index     int  // index of the primitive within the geometry of its mesh
+	light     bool // whether the hit primitive is one of the lights sampled by the traced BVH
 }
 
 type intersectable interface {
@@ -19,9 +25,19 @@ type intersectable interface {
 
 type primitive interface {
 	intersectable
+	// Any hit test between tMin and tMax, which is cheaper than searching the closest hit
+	occluded(ray ray, tMin, tMax float64) bool
 	transformed(Matrix4) primitive
 }
 
+// Primitives implementing samplable can be used as area lights for next event estimation
+type samplable interface {
+	// Samples a point on the primitive as seen from origin. Returns the point, the surface normal and the pdf with respect to solid angle
+	sample(origin Vector3, u, v float64) (point Vector3, normal Vector3, pdf float64)
+	// Pdf with respect to solid angle of sampling point from origin
+	pdf(origin Vector3, point Vector3) float64
+}
+
 type Sphere struct {
 	center Vector3
 	radius float64
@@ -54,6 +70,28 @@ func (s *Sphere) bounding() aabb {
 }
 
 func (s *Sphere) intersected(ray ray, tMin, tMax float64, hitOut *hit) bool {
+	t, ok := s.distance(ray, tMin, tMax)
+	if !ok {
+		return false
+	}
+	hitOut.point = ray.position(t)
+	hitOut.normal = hitOut.point.Sub(s.center).Mul(1 / s.radius)
+	hitOut.uv = sphericalTexCoord(hitOut.normal)
+	hitOut.frontFace = ray.direction.Dot(hitOut.normal) < 0
+	if !hitOut.frontFace {
+		hitOut.normal = hitOut.normal.Mul(-1)
+	}
+	hitOut.t = t
+	return true
+}
+
+func (s *Sphere) occluded(ray ray, tMin, tMax float64) bool {
+	_, ok := s.distance(ray, tMin, tMax)
+	return ok
+}
+
+// Nearest intersection distance within tMin and tMax, false if there is none
+func (s *Sphere) distance(ray ray, tMin, tMax float64) (float64, bool) {
 	oc := ray.origin.Sub(s.center)
 	dirNorm := ray.direction.Length()
 	a := dirNorm * dirNorm
@@ -62,32 +100,68 @@ func (s *Sphere) intersected(ray ray, tMin, tMax float64, hitOut *hit) bool {
 	c := ocNorm*ocNorm - s.radius*s.radius
 	discriminant := halfB*halfB - a*c
 	if discriminant < 0 {
-		return false
+		return 0, false
 	}
 
-	// Nearest intersection distance within tMin <= t <= tMax
 	sqrtDiscriminant := math.Sqrt(discriminant)
 	t := (-halfB - sqrtDiscriminant) / a
 	if t <= tMin || t >= tMax {
 		t = (-halfB + sqrtDiscriminant) / a
 		if t <= tMin || t >= tMax {
-			return false
+			return 0, false
 		}
 	}
+	return t, true
+}
 
-	hitOut.point = ray.position(t)
-	hitOut.normal = hitOut.point.Sub(s.center).Mul(1 / s.radius)
-	hitOut.frontFace = ray.direction.Dot(hitOut.normal) < 0
-	if !hitOut.frontFace {
-		hitOut.normal = hitOut.normal.Mul(-1)
+// Maps a unit vector to latitude-longitude texture coordinates
+func sphericalTexCoord(dir Vector3) texCoord {
+	theta := math.Acos(Clamp(dir.Y, -1, 1))
+	phi := math.Atan2(-dir.Z, dir.X) + math.Pi
+	return texCoord{phi / (2 * math.Pi), 1 - theta/math.Pi}
+}
+
+func (s *Sphere) sample(origin Vector3, u, v float64) (Vector3, Vector3, float64) {
+	toCenter := s.center.Sub(origin)
+	distSquared := toCenter.LengthSquared()
+	if distSquared <= s.radius*s.radius {
+		// Origin lies within the sphere, fall back to uniform area sampling
+		normal := sampleUnitSphere(u, v)
+		point := s.center.Add(normal.Mul(s.radius))
+		return point, normal, s.pdf(origin, point)
 	}
-	hitOut.t = t
-	return true
+
+	// Uniformly sample the cone of directions subtended by the sphere
+	cosThetaMax := math.Sqrt(math.Max(0, 1-s.radius*s.radius/distSquared))
+	cosTheta := 1 - u*(1-cosThetaMax)
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * v
+	w := toCenter.Unit()
+	a, b := orthonormalBasis(w)
+	direction := a.Mul(math.Cos(phi) * sinTheta).Add(b.Mul(math.Sin(phi) * sinTheta)).Add(w.Mul(cosTheta))
+
+	// Distance to the first intersection of the sampled direction with the sphere
+	dist := math.Sqrt(distSquared)
+	distToPoint := dist*cosTheta - math.Sqrt(math.Max(0, s.radius*s.radius-distSquared*sinTheta*sinTheta))
+	point := origin.Add(direction.Mul(distToPoint))
+	normal := point.Sub(s.center).Mul(1 / s.radius)
+	return point, normal, 1 / (2 * math.Pi * (1 - cosThetaMax))
+}
+
+func (s *Sphere) pdf(origin Vector3, point Vector3) float64 {
+	distSquared := s.center.Sub(origin).LengthSquared()
+	if distSquared <= s.radius*s.radius {
+		area := 4 * math.Pi * s.radius * s.radius
+		return areaToSolidAngle(1/area, origin, point, point.Sub(s.center))
+	}
+	cosThetaMax := math.Sqrt(math.Max(0, 1-s.radius*s.radius/distSquared))
+	return 1 / (2 * math.Pi * (1 - cosThetaMax))
 }
 
 type vertex struct {
 	position Vector3
 	normal   Vector3
+	uv       texCoord
 }
 
 type Triangle struct {
@@ -149,6 +223,14 @@ func (tri *Triangle) normal(u, v float64) Vector3 {
 	return normalU.Add(normalV).Add(normalW)
 }
 
+// Takes u and v barycentric coordinates and returns the texture coordinates at point p
+func (tri *Triangle) texCoord(u, v float64) texCoord {
+	uvW := tri.vertecies[0].uv.mul(1 - u - v)
+	uvU := tri.vertecies[1].uv.mul(u)
+	uvV := tri.vertecies[2].uv.mul(v)
+	return uvU.add(uvV).add(uvW)
+}
+
 func (tri *Triangle) transformed(t Matrix4) primitive {
 
 	tinv := t.Transpose().Inverse()
@@ -157,65 +239,124 @@ func (tri *Triangle) transformed(t Matrix4) primitive {
 	vertecies[0] = vertex{
 		position: tri.vertecies[0].position.ToPoint().Transformed(t).ToV3(),
 		normal:   tri.vertecies[0].normal.ToPoint().Transformed(tinv).ToV3(),
+		uv:       tri.vertecies[0].uv,
 	}
 	vertecies[1] = vertex{
 		position: tri.vertecies[1].position.ToPoint().Transformed(t).ToV3(),
 		normal:   tri.vertecies[1].normal.ToPoint().Transformed(tinv).ToV3(),
+		uv:       tri.vertecies[1].uv,
 	}
 	vertecies[2] = vertex{
 		position: tri.vertecies[2].position.ToPoint().Transformed(t).ToV3(),
 		normal:   tri.vertecies[2].normal.ToPoint().Transformed(tinv).ToV3(),
+		uv:       tri.vertecies[2].uv,
 	}
 	return NewTriangle(vertecies)
 }
 
 func (tri *Triangle) intersected(ray ray, tMin, tMax float64, hitOut *hit) bool {
+	t, u, v, det, ok := tri.distance(ray, tMin, tMax)
+	if !ok {
+		return false
+	}
+	hitOut.point = ray.position(t)
+	hitOut.frontFace = det > 0
+	hitOut.normal = tri.normal(u, v).Unit()
+	hitOut.uv = tri.texCoord(u, v)
+	hitOut.u, hitOut.v = u, v
+	if !hitOut.frontFace {
+		hitOut.normal = hitOut.normal.Mul(-1)
+	}
+	hitOut.t = t
+	return true
+}
+
+func (tri *Triangle) occluded(ray ray, tMin, tMax float64) bool {
+	_, _, _, _, ok := tri.distance(ray, tMin, tMax)
+	return ok
+}
+
+// Intersection distance within tMin and tMax, the barycentric coordinates u and v of the hit and the determinant,
+// which is positive for hits from the front. False if the triangle is missed
+func (tri *Triangle) distance(ray ray, tMin, tMax float64) (t, u, v, det float64, ok bool) {
 	// Implementation of the Möller-Trumbore algorithm
 	pvec := ray.direction.Cross(tri.v0v2)
-	det := tri.v0v1.Dot(pvec)
+	det = tri.v0v1.Dot(pvec)
 
 	// If det is close to 0, Triangle and ray are parallel => no intersection
 	if ApproxZero(det) {
-		return false
+		return
 	}
 
 	invDet := 1 / det
 	tvec := ray.origin.Sub(tri.vertecies[0].position)
-	u := tvec.Dot(pvec) * invDet
+	u = tvec.Dot(pvec) * invDet
 	if u < 0 || u > 1 {
-		return false
+		return
 	}
 
 	qvec := tvec.Cross(tri.v0v1)
-	v := ray.direction.Dot(qvec) * invDet
+	v = ray.direction.Dot(qvec) * invDet
 	if v < 0 || u+v > 1 {
-		return false
+		return
 	}
 
-	t := tri.v0v2.Dot(qvec) * invDet
+	t = tri.v0v2.Dot(qvec) * invDet
 	if t < tMin || t > tMax {
-		return false
+		return
 	}
+	return t, u, v, det, true
+}
 
-	hitOut.point = ray.position(t)
-	hitOut.frontFace = det > 0
-	hitOut.normal = tri.normal(u, v)
-	if !hitOut.frontFace {
-		hitOut.normal = hitOut.normal.Mul(-1)
+// Samples a point uniformly on the triangle surface
+func (tri *Triangle) sample(origin Vector3, u, v float64) (Vector3, Vector3, float64) {
+	su := math.Sqrt(u)
+	b1 := 1 - su
+	b2 := v * su
+	point := tri.vertecies[0].position.Add(tri.v0v1.Mul(b1)).Add(tri.v0v2.Mul(b2))
+	normal := tri.v0v1.Cross(tri.v0v2).Unit()
+	return point, normal, tri.pdf(origin, point)
+}
+
+func (tri *Triangle) pdf(origin Vector3, point Vector3) float64 {
+	cross := tri.v0v1.Cross(tri.v0v2)
+	area := cross.Length() / 2
+	return areaToSolidAngle(1/area, origin, point, cross)
+}
+
+// Converts a pdf with respect to surface area into a pdf with respect to solid angle as seen from origin
+func areaToSolidAngle(pdfArea float64, origin Vector3, point Vector3, normal Vector3) float64 {
+	toPoint := point.Sub(origin)
+	distSquared := toPoint.LengthSquared()
+	cos := math.Abs(normal.Unit().Dot(toPoint)) / math.Sqrt(distSquared)
+	if ApproxZero(cos) {
+		return 0
 	}
-	hitOut.t = t
-	return true
+	return pdfArea * distSquared / cos
 }
 
-// Wrapper for pimitive including a material
+// Wrapper for pimitive including a material.
+// Tracables without material, like instances, keep the material and primitive set by the nested hit
 type tracable struct {
-	prim primitive
-	mat  Material
+	prim  primitive
+	mat   Material
+	node  *SceneNode // node the tracable was collected from, nil for meshes shared by instances
+	index int        // index of the primitive within the geometry of its mesh
+	light bool       // set by BVH.collectLights if the tracable is sampled as light
 }
 
 func (p tracable) intersected(ray ray, tMin, tMax float64, hitOut *hit) bool {
 	if p.prim.intersected(ray, tMin, tMax, hitOut) {
-		hitOut.material = p.mat
+		if p.mat != nil {
+			hitOut.material = p.mat
+			hitOut.prim = p.prim
+			hitOut.node = p.node
+			hitOut.index = p.index
+		} else if p.node != nil {
+			hitOut.node = p.node
+		}
+		// Primitives hit within instances are not sampled as lights, only the outermost tracable is
+		hitOut.light = p.light
 		return true
 	}
 	return false
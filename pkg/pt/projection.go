@@ -0,0 +1,52 @@
+package pt
+
+import (
+	"math"
+)
+
+// Maps viewport coordinates s and t in [0, 1], starting at the lower left corner, to the origin and direction of a primary ray.
+// Both are given in camera space, where x points right, y up and the camera looks along -z.
+// Returns false for coordinates outside of the projected image
+type Projection func(s, t float64) (origin, direction Vector3, ok bool)
+
+// Parallel rays through a viewport of the given width in scene units, used e.g. for plans and elevations
+func OrthographicProjection(width, aspectRatio float64) Projection {
+	height := width / aspectRatio
+	return func(s, t float64) (Vector3, Vector3, bool) {
+		origin := NewVector3((s-0.5)*width, (t-0.5)*height, 0)
+		return origin, NewVector3(0, 0, -1), true
+	}
+}
+
+// Equidistant fisheye with a circular image, the angle to the view direction grows linearly with the distance to the image center.
+// fov is the full angle in degrees covered by the diameter of the circle, which fits the height of the image
+func FisheyeProjection(fov, aspectRatio float64) Projection {
+	halfAngle := DegreesToRadians(fov) / 2
+	return func(s, t float64) (Vector3, Vector3, bool) {
+		x := (2*s - 1) * aspectRatio
+		y := 2*t - 1
+		r := math.Sqrt(x*x + y*y)
+		if r > 1 {
+			return Vector3{}, Vector3{}, false
+		}
+		theta := r * halfAngle
+		sinTheta := math.Sin(theta)
+		if r > 0 {
+			x /= r
+			y /= r
+		}
+		return Vector3{}, NewVector3(sinTheta*x, sinTheta*y, -math.Cos(theta)), true
+	}
+}
+
+// Full 360° latitude-longitude panorama, the view direction is at the center of the image.
+// The image should have an aspect ratio of 2 to avoid distortions
+func EquirectangularProjection() Projection {
+	return func(s, t float64) (Vector3, Vector3, bool) {
+		phi := (s - 0.5) * 2 * math.Pi
+		latitude := (t - 0.5) * math.Pi
+		cosLatitude := math.Cos(latitude)
+		direction := NewVector3(cosLatitude*math.Sin(phi), math.Sin(latitude), -cosLatitude*math.Cos(phi))
+		return Vector3{}, direction, true
+	}
+}
@@ -0,0 +1,80 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestProjections(t *testing.T) {
+	sqrtHalf := math.Sqrt(0.5)
+	tests := []struct {
+		name       string
+		projection Projection
+		s, t       float64
+		ok         bool
+		origin     Vector3
+		direction  Vector3
+	}{
+		{"orthographic center", OrthographicProjection(4, 2), 0.5, 0.5, true, NewVector3(0, 0, 0), NewVector3(0, 0, -1)},
+		{"orthographic corner", OrthographicProjection(4, 2), 0, 1, true, NewVector3(-2, 1, 0), NewVector3(0, 0, -1)},
+		{"fisheye center", FisheyeProjection(180, 1), 0.5, 0.5, true, Vector3{}, NewVector3(0, 0, -1)},
+		{"fisheye right", FisheyeProjection(180, 1), 1, 0.5, true, Vector3{}, NewVector3(1, 0, 0)},
+		{"fisheye halfway up", FisheyeProjection(180, 1), 0.5, 0.75, true, Vector3{}, NewVector3(0, sqrtHalf, -sqrtHalf)},
+		{"fisheye wide image", FisheyeProjection(90, 2), 0.75, 0.5, true, Vector3{}, NewVector3(sqrtHalf, 0, -sqrtHalf)},
+		{"fisheye outside", FisheyeProjection(180, 1), 0.95, 0.95, false, Vector3{}, Vector3{}},
+		{"equirectangular center", EquirectangularProjection(), 0.5, 0.5, true, Vector3{}, NewVector3(0, 0, -1)},
+		{"equirectangular right", EquirectangularProjection(), 0.75, 0.5, true, Vector3{}, NewVector3(1, 0, 0)},
+		{"equirectangular behind", EquirectangularProjection(), 0, 0.5, true, Vector3{}, NewVector3(0, 0, 1)},
+		{"equirectangular up", EquirectangularProjection(), 0.3, 1, true, Vector3{}, NewVector3(0, 1, 0)},
+	}
+	for _, test := range tests {
+		origin, direction, ok := test.projection(test.s, test.t)
+		if ok != test.ok {
+			t.Errorf("%v: ok is %v", test.name, ok)
+			continue
+		}
+		if ok && (origin.Sub(test.origin).Length() > 1e-9 || direction.Sub(test.direction).Length() > 1e-9) {
+			t.Errorf("%v: ray from %v along %v, expected %v along %v", test.name, origin, direction, test.origin, test.direction)
+		}
+	}
+}
+
+func TestFisheyeAngle(t *testing.T) {
+	projection := FisheyeProjection(200, 1.5)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		s, tt := r.Float64(), r.Float64()
+		_, direction, ok := projection(s, tt)
+		radius := math.Hypot((2*s-1)*1.5, 2*tt-1)
+		if ok != (radius <= 1) {
+			t.Fatalf("%v,%v at radius %v is inside the image: %v", s, tt, radius, ok)
+		}
+		if !ok {
+			continue
+		}
+		// The angle to the view direction grows linearly up to half the field of view
+		angle := math.Acos(-direction.Z / direction.Length())
+		if math.Abs(direction.Length()-1) > 1e-9 || math.Abs(angle-radius*DegreesToRadians(100)) > 1e-6 {
+			t.Fatalf("%v,%v at radius %v has an angle of %v", s, tt, radius, angle)
+		}
+	}
+}
+
+func TestCameraProjection(t *testing.T) {
+	cam := NewCamera(1, 60, CameraTransformation{NewVector3(1, 2, 3), NewVector3(1, 2, 0), NewVector3(0, 1, 0)})
+	sampler := NewIndependentSampler().clone(rand.New(rand.NewSource(1)), 1, 0)
+	cam.SetProjection(OrthographicProjection(2, 1))
+	r := ray{}
+	if !cam.castRay(0, 1, sampler, &r) || r.origin.Sub(NewVector3(0, 3, 3)).Length() > 1e-9 || r.direction.Sub(NewVector3(0, 0, -1)).Length() > 1e-9 {
+		t.Errorf("orthographic ray from %v along %v", r.origin, r.direction)
+	}
+	cam.SetProjection(FisheyeProjection(180, 1))
+	if cam.castRay(0, 0, sampler, &r) {
+		t.Error("ray cast outside of the fisheye image")
+	}
+	cam.SetProjection(nil)
+	if !cam.castRay(0.5, 0.5, sampler, &r) || r.origin != cam.Origin() || r.direction.Unit().Sub(NewVector3(0, 0, -1)).Length() > 1e-9 {
+		t.Errorf("perspective ray from %v along %v after removing the projection", r.origin, r.direction)
+	}
+}
@@ -0,0 +1,106 @@
+package pt
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Number of queries handed to a thread at once by the batched queries
+const QUERY_BATCH_SIZE = 256
+
+// Ray for queries outside of rendering, hits are searched between TMin and TMax in multiples of Direction
+type RayQuery struct {
+	Origin    Vector3
+	Direction Vector3
+	TMin      float64
+	TMax      float64
+}
+
+// Closest hit of a RayQuery
+type Hit struct {
+	Distance    float64 // in multiples of the query direction
+	Point       Vector3
+	Normal      Vector3 // unit shading normal, always pointing against the ray
+	FrontFace   bool    // whether the ray hit from the outside
+	Barycentric Vector3 // weights of the three vertices for triangles, zero for other primitives
+	// Node of the scene the hit mesh belongs to, nil if the BVH was not compiled from a scene
+	Node *SceneNode
+	// Index of the hit primitive within the geometry of the mesh
+	Primitive int
+}
+
+func newHit(h *hit) Hit {
+	out := Hit{
+		Distance:  h.t,
+		Point:     h.point,
+		Normal:    h.normal,
+		FrontFace: h.frontFace,
+		Node:      h.node,
+		Primitive: h.index,
+	}
+	if _, ok := h.prim.(*Triangle); ok {
+		out.Barycentric = NewVector3(1-h.u-h.v, h.u, h.v)
+	}
+	return out
+}
+
+// Returns the closest hit along the ray between tMin and tMax, false if nothing is hit
+func (bvh *BVH) Intersect(origin, direction Vector3, tMin, tMax float64) (Hit, bool) {
+	h := hit{}
+	if !bvh.intersected(newRay(origin, direction), tMin, tMax, &h) {
+		return Hit{}, false
+	}
+	return newHit(&h), true
+}
+
+// Returns the closest hit of query, see Intersect
+func (bvh *BVH) IntersectQuery(query RayQuery) (Hit, bool) {
+	return bvh.Intersect(query.Origin, query.Direction, query.TMin, query.TMax)
+}
+
+// Intersects all queries in parallel. The hit of query i is stored at index i, ok[i] is false if it missed
+func (bvh *BVH) IntersectBatch(queries []RayQuery) (hits []Hit, ok []bool) {
+	hits = make([]Hit, len(queries))
+	ok = make([]bool, len(queries))
+	parallelQueries(len(queries), func(i int) {
+		hits[i], ok[i] = bvh.IntersectQuery(queries[i])
+	})
+	return hits, ok
+}
+
+// Tests all queries for any hit between TMin and TMax in parallel
+func (bvh *BVH) OccludedBatch(queries []RayQuery) []bool {
+	occluded := make([]bool, len(queries))
+	parallelQueries(len(queries), func(i int) {
+		q := queries[i]
+		occluded[i] = bvh.occluded(newRay(q.Origin, q.Direction), q.TMin, q.TMax)
+	})
+	return occluded
+}
+
+// Calls query for all indeces below n, distributed in chunks of QUERY_BATCH_SIZE over all threads
+func parallelQueries(n int, query func(i int)) {
+	threads := runtime.GOMAXPROCS(0)
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(threads)
+	for t := 0; t < threads; t++ {
+		go func() {
+			defer wg.Done()
+			for start := range jobs {
+				end := start + QUERY_BATCH_SIZE
+				if end > n {
+					end = n
+				}
+				for i := start; i < end; i++ {
+					query(i)
+				}
+			}
+		}()
+	}
+	for start := 0; start < n; start += QUERY_BATCH_SIZE {
+		jobs <- start
+	}
+	close(jobs)
+	wg.Wait()
+}
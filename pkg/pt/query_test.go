@@ -0,0 +1,93 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestIntersect(t *testing.T) {
+	triangle := NewTriangleWithoutNormals(NewVector3(-1, -1, 0), NewVector3(1, -1, 0), NewVector3(-1, 1, 0))
+	mesh := NewMesh(Geometry{NewSphere(NewVector3(5, 0, 0), 1), triangle}, Diffuse{})
+	node := NewSceneNode(mesh)
+	node.Translate(0, 0, -2)
+	scene := NewScene()
+	scene.Add(node)
+	bvh := scene.Compile()
+
+	tests := []struct {
+		name     string
+		query    RayQuery
+		ok       bool
+		expected Hit
+	}{
+		{"triangle", RayQuery{NewVector3(-0.5, -0.5, 1), NewVector3(0, 0, -2), 0, 10}, true, Hit{
+			Distance:    1.5,
+			Point:       NewVector3(-0.5, -0.5, -2),
+			Normal:      NewVector3(0, 0, 1),
+			FrontFace:   true,
+			Barycentric: NewVector3(0.5, 0.25, 0.25),
+			Node:        node,
+			Primitive:   1,
+		}},
+		{"back face", RayQuery{NewVector3(-0.5, -0.5, -3), NewVector3(0, 0, 1), 0, 10}, true, Hit{
+			Distance:    1,
+			Point:       NewVector3(-0.5, -0.5, -2),
+			Normal:      NewVector3(0, 0, -1),
+			FrontFace:   false,
+			Barycentric: NewVector3(0.5, 0.25, 0.25),
+			Node:        node,
+			Primitive:   1,
+		}},
+		{"sphere", RayQuery{NewVector3(5, 0, 2), NewVector3(0, 0, -1), 0, 10}, true, Hit{
+			Distance:  3,
+			Point:     NewVector3(5, 0, -1),
+			Normal:    NewVector3(0, 0, 1),
+			FrontFace: true,
+			Node:      node,
+			Primitive: 0,
+		}},
+		{"beyond tMax", RayQuery{NewVector3(5, 0, 2), NewVector3(0, 0, -1), 0, 2.5}, false, Hit{}},
+		{"before tMin", RayQuery{NewVector3(-0.5, -0.5, 1), NewVector3(0, 0, -1), 4, 10}, false, Hit{}},
+		{"miss", RayQuery{NewVector3(2, 2, 1), NewVector3(0, 0, -1), 0, math.Inf(1)}, false, Hit{}},
+	}
+	for _, test := range tests {
+		got, ok := bvh.IntersectQuery(test.query)
+		if ok != test.ok {
+			t.Errorf("%v: hit %v, expected %v", test.name, ok, test.ok)
+			continue
+		}
+		near := func(a, b Vector3) bool { return a.Sub(b).Length() < 1e-9 }
+		if math.Abs(got.Distance-test.expected.Distance) > 1e-9 || !near(got.Point, test.expected.Point) || !near(got.Normal, test.expected.Normal) ||
+			!near(got.Barycentric, test.expected.Barycentric) || got.FrontFace != test.expected.FrontFace || got.Node != test.expected.Node || got.Primitive != test.expected.Primitive {
+			t.Errorf("%v: got %+v, expected %+v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestQueryBatches(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	bvh := bvhTestScene(r).Compile()
+	// More than one batch, not a multiple of the batch size
+	queries := make([]RayQuery, 3*QUERY_BATCH_SIZE+17)
+	for i, ray := range bvhTestRays(r, len(queries)) {
+		queries[i] = RayQuery{Origin: ray.origin, Direction: ray.direction, TMin: 0.0001, TMax: r.Float64() * 2}
+	}
+	hits, ok := bvh.IntersectBatch(queries)
+	occluded := bvh.OccludedBatch(queries)
+	if len(hits) != len(queries) || len(ok) != len(queries) || len(occluded) != len(queries) {
+		t.Fatalf("%v queries returned %v hits and %v occlusions", len(queries), len(hits), len(occluded))
+	}
+	for i, query := range queries {
+		expected, expectedOk := bvh.IntersectQuery(query)
+		if ok[i] != expectedOk || hits[i] != expected {
+			t.Fatalf("batched query %v hit %v at %v, expected %v at %v", i, ok[i], hits[i].Distance, expectedOk, expected.Distance)
+		}
+		if occluded[i] != expectedOk {
+			t.Fatalf("batched query %v is occluded %v, expected %v", i, occluded[i], expectedOk)
+		}
+	}
+	if hits, ok := bvh.IntersectBatch(nil); len(hits) != 0 || len(ok) != 0 {
+		t.Error("empty batch returned hits")
+	}
+}
@@ -1,6 +1,7 @@
 package pt
 
 import (
+	gocontext "context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -27,6 +28,30 @@ type ImageRenderer struct {
 	Miss     MissShader
 	Sampling Sampling
 	Verbose  bool
+	// Draws the random numbers of the pixel, lens, light and bsdf dimensions, every worker uses its own copy
+	Sampler Sampler
+	// Renders with equal seeds and settings are identical, independent of NumCPU
+	Seed int64
+	// Seen through EnvironmentMissShader and sampled as lights by LightSamplingShader
+	Environment   Environment // may be nil
+	DistantLights []DistantLight
+	// Stops sampling converged parts of the image, Spp is then the maximum number of samples per pixel
+	Adaptive AdaptiveSampling
+}
+
+// Settings of adaptive sampling, which requires a buffer keeping the variance of its pixels like PixelBuffer
+type AdaptiveSampling struct {
+	MinSpp         int     // samples every pixel receives before it is tested for convergence
+	NoiseThreshold float64 // relative standard error of a pixel below which it is converged, 0 disables adaptive sampling
+	TileSize       int     // width and height of the tiles, which stop sampling once all their pixels are converged
+}
+
+func DefaultAdaptiveSampling() AdaptiveSampling {
+	return AdaptiveSampling{
+		MinSpp:         16,
+		NoiseThreshold: 0.02,
+		TileSize:       8,
+	}
 }
 
 func NewDefaultRenderer(bvh BVH, camera *Camera) *ImageRenderer {
@@ -39,6 +64,7 @@ func NewDefaultRenderer(bvh BVH, camera *Camera) *ImageRenderer {
 		Closest:  DefaultClosestHitShader,
 		Miss:     DefaultMissShader,
 		Sampling: RandomSampling,
+		Sampler:  NewIndependentSampler(),
 		Verbose:  false,
 	}
 }
@@ -53,6 +79,7 @@ func NewRealtimeRenderer(bvh BVH, camera *Camera) *ImageRenderer {
 		Closest:  DefaultClosestHitShader,
 		Miss:     SkyMissShader,
 		Sampling: RandomSampling,
+		Sampler:  NewIndependentSampler(),
 		Verbose:  false,
 	}
 }
@@ -67,13 +94,38 @@ func NewBenchmarkRenderer(bvh BVH, camera *Camera) *ImageRenderer {
 		Closest:  DefaultClosestHitShader,
 		Miss:     WhiteMissShader,
 		Sampling: RandomSampling,
+		Sampler:  NewIndependentSampler(),
 		Verbose:  false,
 	}
 }
 
 type context struct {
-	rand  *rand.Rand
-	depth int
+	sampler Sampler
+	depth   int
+	bsdfPdf float64    // pdf of the bsdf sample that generated the current ray, 0 for camera rays and specular bounces
+	rays    *uint64    // number of rays traced by the worker, may be nil
+	aov     *aovSample // output variables of the current camera sample, nil unless rendering into an AOVBuffer
+}
+
+// Row of the image rendered during a pass
+type renderJob struct {
+	y    int
+	pass int
+}
+
+// Options of a progressive render
+type RenderOptions struct {
+	// Called after each finished pass, no samples are added to the buffer while it runs
+	Progress func(Progress)
+}
+
+type Progress struct {
+	Pass    int // index of the finished pass
+	Passes  int // total number of passes
+	Elapsed time.Duration
+	Rays    uint64 // rays traced since the render started
+	// Fraction of tiles that stopped sampling, always 0 without adaptive sampling
+	Converged float64
 }
 
 func (r *ImageRenderer) GetCamera() *Camera {
@@ -89,43 +141,154 @@ func (r *ImageRenderer) GetBvh() BVH {
 }
 
 func (r *ImageRenderer) RenderToBuffer(buff Buffer) {
+	r.RenderContext(gocontext.Background(), buff, RenderOptions{})
+}
+
+// Renders Spp passes into buff, one sample per pixel and pass. An AOVBuffer additionally receives the output variables of each sample.
+// With adaptive sampling converged tiles are skipped in the following passes and rendering ends early once all tiles converged.
+// Stops after the rows in progress once ctx is cancelled and returns the error of ctx, the last pass is then incomplete and not reported as progress
+func (r *ImageRenderer) RenderContext(ctx gocontext.Context, buff Buffer, opts RenderOptions) error {
 	r.log("Started rendering\n")
-	jobs := make(chan int, buff.Height())
+	start := time.Now()
+	jobs := make(chan renderJob, buff.Height())
+	rows := sync.WaitGroup{}
 	wg := sync.WaitGroup{}
 	wg.Add(r.NumCPU)
 	width := buff.Width()
 	height := buff.Height()
+	rays := make([]uint64, r.NumCPU)
+	aovs, recording := buff.(*AOVBuffer)
+	tiles := r.newTiles(buff)
+	sampler := r.Sampler
+	if sampler == nil {
+		sampler = NewIndependentSampler()
+	}
 	for i := 0; i < r.NumCPU; i++ {
-		go func(c context, w, h int) {
-			ray := ray{
-				origin: r.Camera.orientation.origin,
-			}
+		source := &sampleSource{}
+		go func(c context, w, h int, source *sampleSource) {
+			ray := ray{}
 			hit := hit{}
-			for y := range jobs {
+			sample := newAOVSample()
+			if recording {
+				c.aov = &sample
+			}
+			for job := range jobs {
+				// Remaining rows are drained without rendering after a cancellation
+				if ctx.Err() != nil {
+					rows.Done()
+					continue
+				}
+				y := job.y
 				for x := 0; x < w; x++ {
+					if !tiles.sampled(x, y) {
+						continue
+					}
+					sample = newAOVSample()
+					source.Seed(sampleSeed(r.Seed, x, y, job.pass))
+					c.sampler.startPixelSample(x, y, job.pass)
 					u, v := r.Sampling(c, x, y, w, h)
-					r.Camera.castRayReuse(u, v, &ray)
-					if r.Bvh.intersected(ray, 0.001, math.Inf(1), &hit) {
-						buff.addSample(x, y, r.Closest(r, c, ray, &hit))
+					if !r.Camera.castRay(u, v, c.sampler, &ray) {
+						buff.addSample(x, y, NewColor(0, 0, 0))
+						continue
+					}
+					var light Color
+					if r.trace(c, ray, 0.001, math.Inf(1), &hit) {
+						if recording {
+							sample.recordHit(ray, &hit)
+						}
+						light = r.Closest(r, c, ray, &hit)
 					} else {
-						buff.addSample(x, y, r.Miss(r, c, ray))
+						light = r.miss(c, ray)
+					}
+					if recording {
+						aovs.addAOVSample(x, y, light, &sample)
+					} else {
+						buff.addSample(x, y, light)
 					}
 				}
+				rows.Done()
 			}
 			wg.Done()
 		}(context{
-			rand: rand.New(rand.NewSource(time.Now().UnixNano())),
-		}, width, height)
+			sampler: sampler.clone(rand.New(source), r.Spp, uint32(mix64(uint64(r.Seed)))),
+			rays:    &rays[i],
+		}, width, height, source)
 	}
+
+	var err error
+passes:
 	for i := 0; i < r.Spp; i++ {
 		for y := 0; y < height; y++ {
-			jobs <- y
+			if !tiles.rowSampled(y) {
+				continue
+			}
+			rows.Add(1)
+			select {
+			case jobs <- renderJob{y: y, pass: i}:
+			case <-ctx.Done():
+				rows.Done()
+				err = ctx.Err()
+				break passes
+			}
+		}
+		// Wait for the pass to finish, so that the buffer is not written to during the callback
+		rows.Wait()
+		if err = ctx.Err(); err != nil {
+			break
 		}
 		r.log("Finished pass %v\n", i)
+		if i+1 >= r.Adaptive.MinSpp {
+			tiles.update()
+		}
+		if opts.Progress != nil {
+			total := uint64(0)
+			for _, n := range rays {
+				total += n
+			}
+			opts.Progress(Progress{
+				Pass:      i,
+				Passes:    r.Spp,
+				Elapsed:   time.Since(start),
+				Rays:      total,
+				Converged: tiles.converged(),
+			})
+		}
+		if tiles.done() {
+			r.log("Converged after pass %v\n", i)
+			break
+		}
 	}
 	close(jobs)
 	wg.Wait()
+	if err != nil {
+		r.log("Cancelled Rendering\n")
+		return err
+	}
 	r.log("Finished Rendering\n")
+	return nil
+}
+
+// Intersects ray with the bvh and counts it
+func (r *ImageRenderer) trace(c context, ray ray, tMin, tMax float64, h *hit) bool {
+	if c.rays != nil {
+		*c.rays++
+	}
+	return r.Bvh.intersected(ray, tMin, tMax, h)
+}
+
+// Calls the miss shader and records its light as direct light of the sample
+func (r *ImageRenderer) miss(c context, ray ray) Color {
+	light := r.Miss(r, c, ray)
+	c.addDirect(light)
+	return light
+}
+
+// Tests ray for any hit with the bvh and counts it
+func (r *ImageRenderer) occluded(c context, ray ray, tMin, tMax float64) bool {
+	if c.rays != nil {
+		*c.rays++
+	}
+	return r.Bvh.occluded(ray, tMin, tMax)
 }
 
 func (r *ImageRenderer) log(message string, a ...interface{}) {
@@ -171,23 +334,26 @@ func (r *HeatMapRenderer) RenderToBuffer(buff Buffer) {
 	width := buff.Width()
 	height := buff.Height()
 	for i := 0; i < r.NumCPU; i++ {
-		go func(c context, w, h int) {
-			ray := ray{
-				origin: r.Camera.orientation.origin,
-			}
+		source := &sampleSource{}
+		go func(c context, w, h int, source *sampleSource) {
+			ray := ray{}
 			for y := range jobs {
 				for x := 0; x < w; x++ {
+					source.Seed(sampleSeed(0, x, y, 0))
 					u := float64(x) / float64(w-1)
 					v := float64(y) / float64(h-1)
-					r.Camera.castRayReuse(u, v, &ray)
+					if !r.Camera.castRay(u, v, c.sampler, &ray) {
+						buff.addSample(x, y, NewColor(0, 0, 0))
+						continue
+					}
 					count := r.Bvh.traversalSteps(ray, 0.001, math.MaxFloat64)
 					buff.addSample(x, y, r.intersectionCount(r, count))
 				}
 			}
 			wg.Done()
 		}(context{
-			rand: rand.New(rand.NewSource(time.Now().UnixNano())),
-		}, width, height)
+			sampler: NewIndependentSampler().clone(rand.New(source), 1, 0),
+		}, width, height, source)
 	}
 	for y := 0; y < height; y++ {
 		jobs <- y
@@ -198,9 +364,11 @@ func (r *HeatMapRenderer) RenderToBuffer(buff Buffer) {
 
 type Sampling func(c context, x, y, w, h int) (u, v float64)
 
+// Jitters the position within the pixel using the first two dimensions of the sampler
 func RandomSampling(c context, x, y, w, h int) (u, v float64) {
-	u = (float64(x) + c.rand.Float64()) / float64(w-1)
-	v = (float64(y) + c.rand.Float64()) / float64(h-1)
+	jitterX, jitterY := c.sampler.get2D()
+	u = (float64(x) + jitterX) / float64(w-1)
+	v = (float64(y) + jitterY) / float64(h-1)
 	return
 }
 
@@ -210,25 +378,179 @@ func DefaultClosestHitShader(renderer *ImageRenderer, c context, r ray, h *hit)
 	if c.depth > renderer.MaxDepth {
 		return NewColor(0, 0, 0)
 	}
-	c.depth++
+	c.sampler.startBounce(c.depth)
 	light := h.material.emittedLight()
-	if b, attenuation := h.material.scatter(&r, h, c.rand); b {
-		if renderer.Bvh.intersected(r, 0.0001, math.Inf(1), h) {
+	c.addDirect(light)
+	c.depth++
+	if b, attenuation := h.material.scatter(&r, h, c.sampler); b {
+		c.scattered(attenuation)
+		if renderer.trace(c, r, 0.0001, math.Inf(1), h) {
 			return light.Add(renderer.Closest(renderer, c, r, h).Blend(attenuation))
 		} else {
-			return light.Add(renderer.Miss(renderer, c, r).Blend(attenuation))
+			return light.Add(renderer.miss(c, r).Blend(attenuation))
 		}
 	} else {
 		return light
 	}
 }
 
+// LightSamplingShader extends the DefaultClosestHitShader by next event estimation.
+// At each bounce a point on an emissive primitive is sampled and connected through a shadow ray.
+// Light and bsdf samples are combined using multiple importance sampling with the power heuristic.
+func LightSamplingShader(renderer *ImageRenderer, c context, r ray, h *hit) Color {
+	if c.depth > renderer.MaxDepth {
+		if c.bsdfPdf == 0 {
+			return NewColor(0, 0, 0)
+		}
+		// Next event estimation at the last bounce only counted its share of the emission
+		light := weightedEmission(renderer, c, r, h)
+		c.addDirect(light)
+		return light
+	}
+	c.sampler.startBounce(c.depth)
+	light := weightedEmission(renderer, c, r, h)
+	c.addDirect(light)
+	c.depth++
+	in := r.direction
+	mat, evaluable := h.material.(bsdf)
+	if evaluable {
+		sampled := directLight(renderer, c, r, h, mat)
+		c.addDirect(sampled)
+		light = light.Add(sampled)
+	}
+	b, attenuation := h.material.scatter(&r, h, c.sampler)
+	if !b {
+		return light
+	}
+	c.scattered(attenuation)
+	c.bsdfPdf = 0
+	if evaluable {
+		_, c.bsdfPdf = mat.evaluate(in, r.direction, h)
+	}
+	if renderer.trace(c, r, 0.0001, math.Inf(1), h) {
+		return light.Add(renderer.Closest(renderer, c, r, h).Blend(attenuation))
+	}
+	return light.Add(renderer.miss(c, r).Blend(attenuation))
+}
+
+// Light emitted at h towards the origin of r, weighted against next event estimation if r was sampled from a bsdf
+func weightedEmission(renderer *ImageRenderer, c context, r ray, h *hit) Color {
+	light := h.material.emittedLight()
+	if c.bsdfPdf > 0 && !light.isBlack() {
+		// The emitter could also have been reached by sampling the light directly
+		light = light.Scale(powerHeuristic(c.bsdfPdf, renderer.Bvh.lightPdf(r.origin, h)*renderer.areaLightProbability()))
+	}
+	return light
+}
+
+// Samples a point on a light, a distant light or a direction of the environment and returns its contribution at h, weighted by the power heuristic
+func directLight(renderer *ImageRenderer, c context, r ray, h *hit, mat bsdf) Color {
+	count := renderer.lightCount()
+	if count == 0 {
+		return NewColor(0, 0, 0)
+	}
+	i := int(math.Min(c.sampler.get1D()*float64(count), float64(count-1)))
+	u, v := c.sampler.get2D()
+	areaLights := len(renderer.Bvh.lights)
+	if i >= areaLights+len(renderer.DistantLights) {
+		env := renderer.Environment
+		direction, lightPdf := env.sample(u, v)
+		return distantContribution(renderer, c, r, h, mat, direction, lightPdf/float64(count), env.radiance(direction))
+	}
+	if i >= areaLights {
+		light := &renderer.DistantLights[i-areaLights]
+		direction, lightPdf := light.sample(u, v)
+		return distantContribution(renderer, c, r, h, mat, direction, lightPdf/float64(count), light.Radiance)
+	}
+	light, point, lightPdf := renderer.Bvh.sampleLight(h.point, i, u, v)
+	lightPdf *= renderer.areaLightProbability()
+	if lightPdf <= 0 {
+		return NewColor(0, 0, 0)
+	}
+	toLight := point.Sub(h.point)
+	f, bsdfPdf := mat.evaluate(r.direction, toLight, h)
+	if f.isBlack() {
+		return NewColor(0, 0, 0)
+	}
+	dist := toLight.Length()
+	shadowRay := newRay(h.point, toLight.Mul(1/dist))
+	shadowRay.time = r.time
+	if renderer.occluded(c, shadowRay, 0.0001, dist*0.999) {
+		return NewColor(0, 0, 0)
+	}
+	weight := powerHeuristic(lightPdf, bsdfPdf)
+	return light.mat.emittedLight().Blend(f).Scale(weight / lightPdf)
+}
+
+// Contribution of radiance arriving at h from infinitely far away along the sampled direction
+func distantContribution(renderer *ImageRenderer, c context, r ray, h *hit, mat bsdf, direction Vector3, lightPdf float64, radiance Color) Color {
+	if lightPdf <= 0 {
+		return NewColor(0, 0, 0)
+	}
+	f, bsdfPdf := mat.evaluate(r.direction, direction, h)
+	if f.isBlack() {
+		return NewColor(0, 0, 0)
+	}
+	shadowRay := newRay(h.point, direction)
+	shadowRay.time = r.time
+	if renderer.occluded(c, shadowRay, 0.0001, math.Inf(1)) {
+		return NewColor(0, 0, 0)
+	}
+	weight := powerHeuristic(lightPdf, bsdfPdf)
+	return radiance.Blend(f).Scale(weight / lightPdf)
+}
+
+// Number of lights directLight chooses from with equal probability: each emissive primitive, each distant light and the environment
+func (r *ImageRenderer) lightCount() int {
+	count := len(r.Bvh.lights) + len(r.DistantLights)
+	if r.Environment != nil {
+		count++
+	}
+	return count
+}
+
+// Probability of directLight sampling one of the area lights of the bvh
+func (r *ImageRenderer) areaLightProbability() float64 {
+	count := r.lightCount()
+	if count == 0 {
+		return 0
+	}
+	return float64(len(r.Bvh.lights)) / float64(count)
+}
+
 type MissShader func(*ImageRenderer, context, ray) Color
 
 func DefaultMissShader(renderer *ImageRenderer, c context, r ray) Color {
 	return NewColor(0, 0, 0)
 }
 
+// Looks up the environment and distant lights of the renderer, black if it has none.
+// Directions reached by a bsdf sample of LightSamplingShader are weighted against sampling these lights directly
+func EnvironmentMissShader(renderer *ImageRenderer, c context, r ray) Color {
+	radiance := NewColor(0, 0, 0)
+	direction := r.direction.Unit()
+	selection := 1 / float64(renderer.lightCount())
+	if env := renderer.Environment; env != nil {
+		l := env.radiance(direction)
+		if c.bsdfPdf > 0 {
+			l = l.Scale(powerHeuristic(c.bsdfPdf, env.pdf(direction)*selection))
+		}
+		radiance = radiance.Add(l)
+	}
+	for i := range renderer.DistantLights {
+		light := &renderer.DistantLights[i]
+		if !light.visible(direction) {
+			continue
+		}
+		l := light.Radiance
+		if c.bsdfPdf > 0 {
+			l = l.Scale(powerHeuristic(c.bsdfPdf, light.pdf()*selection))
+		}
+		radiance = radiance.Add(l)
+	}
+	return radiance
+}
+
 func WhiteMissShader(renderer *ImageRenderer, c context, r ray) Color {
 	return NewColor(1, 1, 1)
 }
@@ -0,0 +1,212 @@
+package pt
+
+import (
+	gocontext "context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRenderContextCancelMidPass(t *testing.T) {
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Diffuse{Albedo: NewColor(.5, .5, .5)})))
+	cam := NewCamera(1, 60, CameraTransformation{NewVector3(0, 0, 3), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+	r := NewDefaultRenderer(scene.Compile(), cam)
+	r.NumCPU = 2
+	r.Spp = 1
+	// Slow shader, so that a single pass takes several seconds
+	r.Closest = func(renderer *ImageRenderer, c context, r ray, h *hit) Color {
+		time.Sleep(100 * time.Microsecond)
+		return NewColor(1, 1, 1)
+	}
+	r.Miss = func(renderer *ImageRenderer, c context, r ray) Color {
+		time.Sleep(100 * time.Microsecond)
+		return NewColor(0, 0, 0)
+	}
+	buff := NewPxlBuffer(200, 200)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	progressed := false
+	start := time.Now()
+	err := r.RenderContext(ctx, buff, RenderOptions{Progress: func(Progress) { progressed = true }})
+	elapsed := time.Since(start)
+
+	if err != gocontext.Canceled {
+		t.Errorf("expected %v, got %v", gocontext.Canceled, err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("render returned %v after it was cancelled", elapsed)
+	}
+	if progressed {
+		t.Error("progress reported for the cancelled pass")
+	}
+	rendered := 0
+	for _, px := range buff.buff {
+		rendered += px.samples
+	}
+	if rendered == len(buff.buff) {
+		t.Error("all pixels rendered despite the cancellation")
+	}
+}
+
+func TestRenderContextProgress(t *testing.T) {
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Diffuse{Albedo: NewColor(.5, .5, .5)})))
+	cam := NewCamera(1, 60, CameraTransformation{NewVector3(0, 0, 3), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+	r := NewDefaultRenderer(scene.Compile(), cam)
+	r.NumCPU = 4
+	r.Spp = 5
+	buff := NewPxlBuffer(20, 20)
+	progress := []Progress{}
+	err := r.RenderContext(gocontext.Background(), buff, RenderOptions{Progress: func(p Progress) { progress = append(progress, p) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != r.Spp {
+		t.Fatalf("progress reported %v times for %v passes", len(progress), r.Spp)
+	}
+	for i, p := range progress {
+		if p.Pass != i || p.Passes != r.Spp || p.Converged != 0 {
+			t.Errorf("progress of pass %v is %+v", i, p)
+		}
+		// Every pass traces at least one camera ray per pixel
+		if i > 0 && p.Rays < progress[i-1].Rays+uint64(len(buff.buff)) {
+			t.Errorf("%v rays after pass %v, %v after the previous one", p.Rays, i, progress[i-1].Rays)
+		}
+	}
+	for i, px := range buff.buff {
+		if px.samples != r.Spp {
+			t.Fatalf("pixel %v has %v samples, expected %v", i, px.samples, r.Spp)
+		}
+	}
+}
+
+func TestRenderContextCancelled(t *testing.T) {
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Diffuse{Albedo: NewColor(.5, .5, .5)})))
+	cam := NewCamera(1, 60, CameraTransformation{NewVector3(0, 0, 3), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+	r := NewDefaultRenderer(scene.Compile(), cam)
+	buff := NewPxlBuffer(20, 20)
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+	if err := r.RenderContext(ctx, buff, RenderOptions{}); err != gocontext.Canceled {
+		t.Errorf("expected %v, got %v", gocontext.Canceled, err)
+	}
+	for i, px := range buff.buff {
+		if px.samples != 0 {
+			t.Fatalf("pixel %v was rendered after the render was cancelled", i)
+		}
+	}
+}
+
+func seedTestRenderer(sampler Sampler, numCPU int, seed int64) *ImageRenderer {
+	scene := NewScene()
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Diffuse{Albedo: NewColor(.5, .5, .5)})))
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, -101, 0), 100)}, Diffuse{Albedo: NewColor(.5, .5, .5)})))
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(-2, 0, 1), .6)}, Refractive{Albedo: NewColor(1, 1, 1), Ratio: 1.5})))
+	scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 4, 0), 1)}, Light{Color: NewColor(8, 8, 8)})))
+	cam := NewCamera(1, 40, CameraTransformation{NewVector3(0, 1, 7), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+	cam.SetLens(Lens{Aperture: 0.1, FocusDistance: 7})
+	r := NewDefaultRenderer(scene.Compile(), cam)
+	r.Closest = LightSamplingShader
+	r.Sampler = sampler
+	r.NumCPU = numCPU
+	r.Seed = seed
+	r.Spp = 8
+	return r
+}
+
+var seedTestSamplers = map[string]func() Sampler{
+	"independent": NewIndependentSampler,
+	"stratified":  NewStratifiedSampler,
+	"halton":      NewHaltonSampler,
+	"sobol":       NewSobolSampler,
+}
+
+func renderSeedTest(sampler Sampler, numCPU int, seed int64, adaptive bool) []Pixel {
+	r := seedTestRenderer(sampler, numCPU, seed)
+	if adaptive {
+		r.Adaptive = DefaultAdaptiveSampling()
+		r.Adaptive.MinSpp = 4
+		r.Adaptive.NoiseThreshold = 0.2
+	}
+	buff := NewPxlBuffer(40, 30)
+	r.RenderToBuffer(buff)
+	return buff.buff
+}
+
+func TestRenderSeedIndependentOfNumCPU(t *testing.T) {
+	for name, sampler := range seedTestSamplers {
+		for _, adaptive := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%v adaptive=%v", name, adaptive), func(t *testing.T) {
+				expected := renderSeedTest(sampler(), 1, 7, adaptive)
+				got := renderSeedTest(sampler(), 8, 7, adaptive)
+				for i := range expected {
+					if got[i] != expected[i] {
+						t.Fatalf("pixel %v is %v with 8 CPUs, expected %v", i, got[i], expected[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRenderSeedChangesOutput(t *testing.T) {
+	for name, sampler := range seedTestSamplers {
+		t.Run(name, func(t *testing.T) {
+			a := renderSeedTest(sampler(), 4, 7, false)
+			b := renderSeedTest(sampler(), 4, 8, false)
+			for i := range a {
+				if a[i] != b[i] {
+					return
+				}
+			}
+			t.Error("different seeds produced the same image")
+		})
+	}
+}
+
+func TestLightSamplingMatchesPathTracing(t *testing.T) {
+	tests := []struct {
+		name     string
+		material Material
+		maxDepth int
+	}{
+		{"diffuse", Diffuse{Albedo: NewColor(.5, .2, .2)}, 3},
+		{"glossy", Glossy{Albedo: NewColor(.4, .2, .2), Specular: NewColor(.3, .3, .3), Roughness: .3}, 3},
+		{"rough conductor", Copper.WithRoughness(.4), 3},
+		{"direct only", Diffuse{Albedo: NewColor(.5, .5, .5)}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scene := NewScene()
+			scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, -101, 0), 100)}, Diffuse{Albedo: NewColor(.5, .5, .5)})))
+			scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, test.material)))
+			scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(1, 3, 1), .5)}, Light{Color: NewColor(10, 10, 10)})))
+			bvh := scene.Compile()
+			cam := NewCamera(1, 40, CameraTransformation{NewVector3(0, 1, 6), NewVector3(0, 0, 0), NewVector3(0, 1, 0)})
+			mean := func(shader ClosestHitShader, maxDepth, spp int) float64 {
+				r := NewDefaultRenderer(bvh, cam)
+				r.Closest = shader
+				r.MaxDepth = maxDepth
+				r.Spp = spp
+				buff := NewPxlBuffer(20, 20)
+				r.RenderToBuffer(buff)
+				sum := 0.0
+				for _, px := range buff.buff {
+					sum += luminance(px.color)
+				}
+				return sum / float64(len(buff.buff))
+			}
+			// Next event estimation reaches the lights one bounce beyond MaxDepth, so path tracing needs one more bounce.
+			// The default miss shader is black, otherwise path tracing would also see the environment at that bounce
+			expected := mean(DefaultClosestHitShader, test.maxDepth+1, 2048)
+			got := mean(LightSamplingShader, test.maxDepth, 256)
+			if math.Abs(got-expected) > 0.02*expected {
+				t.Errorf("mean luminance %v, expected %v", got, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package pt
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+const (
+	DEFAULT_SAH_BINS      = 16
+	DEFAULT_SAH_LEAF_SIZE = 4
+	// Subtrees with fewer primitives are built by the thread that created them
+	SAH_PARALLEL_THRESHOLD = 4096
+)
+
+// Top down builder that splits nodes at the best of a fixed number of bins along each axis according to the surface area heuristic
+type SAHBuilder struct {
+	Bins            int // candidate split planes per axis are the borders between bins
+	LeafSize        int // nodes with at most LeafSize primitives become leaves
+	BranchingFactor int
+	threadCount     int
+	primitives      []tracable
+	boxes           []aabb // cached bounding boxes of the primitives
+	workers         chan struct{}
+}
+
+func NewDefaultSAHBuilder(primitives []tracable) SAHBuilder {
+	return NewSAHBuilder(primitives, DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, runtime.GOMAXPROCS(0))
+}
+
+// Bins, leafSize and threadCount below 1 and a branchingFactor below 2 are raised to these minimums when building
+func NewSAHBuilder(primitives []tracable, bins, leafSize, branchingFactor, threadCount int) SAHBuilder {
+	return SAHBuilder{
+		Bins:            bins,
+		LeafSize:        leafSize,
+		BranchingFactor: branchingFactor,
+		threadCount:     threadCount,
+		primitives:      primitives,
+	}
+}
+
+// Raises parameters the builder can not terminate with to their minimum
+func (b *SAHBuilder) clamp() {
+	b.Bins = atLeast(b.Bins, 1)
+	b.LeafSize = atLeast(b.LeafSize, 1)
+	b.BranchingFactor = atLeast(b.BranchingFactor, 2)
+	b.threadCount = atLeast(b.threadCount, 1)
+}
+
+func atLeast(v, min int) int {
+	if v < min {
+		return min
+	}
+	return v
+}
+
+func (b *SAHBuilder) Build() BVH {
+	b.clamp()
+	if len(b.primitives) == 0 {
+		return emptyBVH()
+	}
+	b.boxes = make([]aabb, len(b.primitives))
+	indeces := make([]int, len(b.primitives))
+	for i, prim := range b.primitives {
+		b.boxes[i] = prim.bounding()
+		indeces[i] = i
+	}
+	// The calling thread is one of the workers
+	b.workers = make(chan struct{}, b.threadCount-1)
+
+	// Temporary branch as a starting point, will be discared afterwards
+	temp := newBranch(1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	b.buildSubTree(sahSet{indeces, b.enclosing(indeces)}, temp, 0, &wg)
+	wg.Wait()
+
+	root := temp.children[0]
+	root.parent = nil
+	return BVH{
+		root:  root,
+		prims: b.primitives,
+	}
+}
+
+// Primitives of a node during construction
+type sahSet struct {
+	indeces  []int
+	bounding aabb
+}
+
+func (b *SAHBuilder) buildSubTree(set sahSet, parent *bvhNode, childIndex int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if len(set.indeces) <= b.LeafSize {
+		leaf := newLeaf(set.indeces)
+		leaf.bounding = set.bounding
+		parent.addChild(leaf, childIndex)
+		return
+	}
+
+	// Keep splitting the biggest set until enough children to branch the tree are found
+	sets := make([]sahSet, 1, b.BranchingFactor)
+	sets[0] = set
+	for len(sets) < b.BranchingFactor {
+		max := 0
+		maxI := 0
+		for i, s := range sets {
+			if len(s.indeces) > max {
+				max = len(s.indeces)
+				maxI = i
+			}
+		}
+		if max <= b.LeafSize {
+			break
+		}
+		left, right := b.split(sets[maxI])
+		sets[maxI] = left
+		sets = append(sets, right)
+	}
+
+	branch := newBranch(len(sets))
+	branch.bounding = set.bounding
+	parent.addChild(branch, childIndex)
+
+	wg.Add(len(sets))
+	for i, s := range sets {
+		if len(s.indeces) < SAH_PARALLEL_THRESHOLD {
+			b.buildSubTree(s, branch, i, wg)
+			continue
+		}
+		// Hand big subtrees to a new thread if one is available
+		select {
+		case b.workers <- struct{}{}:
+			go func(s sahSet, i int) {
+				b.buildSubTree(s, branch, i, wg)
+				<-b.workers
+			}(s, i)
+		default:
+			b.buildSubTree(s, branch, i, wg)
+		}
+	}
+}
+
+type sahBin struct {
+	bounding aabb
+	count    int
+}
+
+// Splits the set at the bin border with the lowest SAH cost, or at the median if all centroids coincide
+func (b *SAHBuilder) split(set sahSet) (sahSet, sahSet) {
+	centroidMin := b.boxes[set.indeces[0]].barycenter
+	centroidMax := centroidMin
+	for _, i := range set.indeces[1:] {
+		centroidMin = MinVec(centroidMin, b.boxes[i].barycenter)
+		centroidMax = MaxVec(centroidMax, b.boxes[i].barycenter)
+	}
+
+	bestCost := math.Inf(1)
+	bestAxis := -1
+	bestBin := 0
+	bins := make([]sahBin, b.Bins)
+	rightSurfaces := make([]float64, b.Bins)
+	for axis := 0; axis < 3; axis++ {
+		min := component(centroidMin, axis)
+		extent := component(centroidMax, axis) - min
+		if extent <= 0 {
+			continue
+		}
+		for i := range bins {
+			bins[i] = sahBin{}
+		}
+		for _, i := range set.indeces {
+			bin := binIndex(b.Bins, component(b.boxes[i].barycenter, axis), min, extent)
+			if bins[bin].count == 0 {
+				bins[bin].bounding = b.boxes[i]
+			} else {
+				bins[bin].bounding = bins[bin].bounding.add(b.boxes[i])
+			}
+			bins[bin].count++
+		}
+
+		cost, bin := sweepBins(bins, rightSurfaces, len(set.indeces))
+		if cost < bestCost {
+			bestCost = cost
+			bestAxis = axis
+			bestBin = bin
+		}
+	}
+
+	var mid int
+	if bestAxis < 0 {
+		mid = len(set.indeces) / 2
+	} else {
+		// Partition in place
+		min := component(centroidMin, bestAxis)
+		extent := component(centroidMax, bestAxis) - min
+		indeces := set.indeces
+		for i := 0; i < len(indeces); i++ {
+			if binIndex(b.Bins, component(b.boxes[indeces[i]].barycenter, bestAxis), min, extent) <= bestBin {
+				indeces[i], indeces[mid] = indeces[mid], indeces[i]
+				mid++
+			}
+		}
+	}
+	left := set.indeces[:mid]
+	right := set.indeces[mid:]
+	return sahSet{left, b.enclosing(left)}, sahSet{right, b.enclosing(right)}
+}
+
+// Returns the lowest SAH cost of splitting between two of the bins holding total primitives,
+// and the index of the last bin left of the split. rightCosts is used as scratch space
+func sweepBins(bins []sahBin, rightCosts []float64, total int) (float64, int) {
+	// Sweep from the right to store the cost of all bins right of each border
+	var right aabb
+	rightCount := 0
+	for i := len(bins) - 1; i > 0; i-- {
+		right, rightCount = extendBin(right, rightCount, bins[i])
+		rightCosts[i] = right.surface() * float64(rightCount)
+	}
+
+	// Sweep from the left and evaluate the cost of splitting at each border
+	bestCost := math.Inf(1)
+	bestBin := 0
+	var left aabb
+	leftCount := 0
+	for i := 0; i < len(bins)-1; i++ {
+		left, leftCount = extendBin(left, leftCount, bins[i])
+		if leftCount == 0 || leftCount == total {
+			continue
+		}
+		cost := left.surface()*float64(leftCount) + rightCosts[i+1]
+		if cost < bestCost {
+			bestCost = cost
+			bestBin = i
+		}
+	}
+	return bestCost, bestBin
+}
+
+func binIndex(bins int, value, min, extent float64) int {
+	bin := int(float64(bins) * (value - min) / extent)
+	if bin >= bins {
+		return bins - 1
+	}
+	if bin < 0 {
+		return 0
+	}
+	return bin
+}
+
+func (b *SAHBuilder) enclosing(indeces []int) aabb {
+	enclosing := b.boxes[indeces[0]]
+	for _, i := range indeces[1:] {
+		enclosing = enclosing.add(b.boxes[i])
+	}
+	return enclosing
+}
+
+func extendBin(box aabb, count int, bin sahBin) (aabb, int) {
+	if bin.count == 0 {
+		return box, count
+	}
+	if count == 0 {
+		return bin.bounding, bin.count
+	}
+	return box.add(bin.bounding), count + bin.count
+}
+
+func component(v Vector3, axis int) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	default:
+		return v.Z
+	}
+}
@@ -0,0 +1,91 @@
+package pt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Fails if a node has more than branchingFactor children, is not enclosed by its parent
+// or if a primitive is referenced less than once, or more than once unless duplicates is set
+func checkBVHStructure(t *testing.T, name string, bvh *BVH, branchingFactor int, duplicates bool) {
+	t.Helper()
+	references := make([]int, len(bvh.prims))
+	var check func(node, parent *bvhNode)
+	check = func(node, parent *bvhNode) {
+		if node.parent != parent {
+			t.Fatalf("%v: node does not point to its parent", name)
+		}
+		if parent != nil {
+			for axis := 0; axis < 3; axis++ {
+				if component(node.bounding.bounds[0], axis) < component(parent.bounding.bounds[0], axis) || component(node.bounding.bounds[1], axis) > component(parent.bounding.bounds[1], axis) {
+					t.Fatalf("%v: bounding box %v exceeds its parent %v", name, node.bounding.bounds, parent.bounding.bounds)
+				}
+			}
+		}
+		if node.isLeaf {
+			for _, prim := range node.prims {
+				references[prim]++
+			}
+			return
+		}
+		if len(node.children) < 2 || len(node.children) > branchingFactor {
+			t.Fatalf("%v: branch has %v children", name, len(node.children))
+		}
+		for _, child := range node.children {
+			check(child, node)
+		}
+	}
+	check(bvh.root, nil)
+	for i, count := range references {
+		if count == 0 || (count > 1 && !duplicates) {
+			t.Fatalf("%v: primitive %v is referenced %v times", name, i, count)
+		}
+	}
+}
+
+func TestSAHBuilder(t *testing.T) {
+	tests := []struct {
+		name                                     string
+		bins, leafSize, branchingFactor, threads int
+	}{
+		{"default", DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, 4},
+		{"single bin", 1, 1, 2, 1},
+		{"wide", 32, 2, 8, 4},
+		{"clamped", 0, -1, 1, 0},
+	}
+	r := rand.New(rand.NewSource(1))
+	scene := bvhTestScene(r)
+	reference := scene.Compile()
+	rays := bvhTestRays(r, 2000)
+	for _, test := range tests {
+		builder := NewSAHBuilder(scene.collectTracables(false), test.bins, test.leafSize, test.branchingFactor, test.threads)
+		bvh := builder.Build()
+		checkBVHStructure(t, test.name, &bvh, builder.BranchingFactor, false)
+		expectSameHits(t, test.name, &reference, &bvh, rays)
+	}
+}
+
+func TestSAHBuilderDegenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		geometry Geometry
+	}{
+		{"empty", Geometry{}},
+		{"single", Geometry{NewSphere(NewVector3(0, 0, 0), 1)}},
+		{"coincident", Geometry{
+			NewSphere(NewVector3(0, 0, 0), 1), NewSphere(NewVector3(0, 0, 0), 1), NewSphere(NewVector3(0, 0, 0), 1),
+			NewSphere(NewVector3(0, 0, 0), 1), NewSphere(NewVector3(0, 0, 0), 1), NewSphere(NewVector3(0, 0, 0), 1),
+		}},
+	}
+	for _, test := range tests {
+		builder := NewSAHBuilder(NewMesh(test.geometry, Diffuse{}).raw(), DEFAULT_SAH_BINS, 1, 2, 2)
+		bvh := builder.Build()
+		if len(test.geometry) > 0 {
+			checkBVHStructure(t, test.name, &bvh, 2, false)
+		}
+		_, ok := bvh.Intersect(NewVector3(0, 0, 5), NewVector3(0, 0, -1), 0, 10)
+		if ok != (len(test.geometry) > 0) {
+			t.Errorf("%v: ray hit %v", test.name, ok)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package pt
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+const (
+	// Dimensions reserved for the camera: pixel position, lens and time
+	SAMPLER_CAMERA_DIMENSIONS = 5
+	// Dimensions reserved for each bounce: light selection, point on the light, bsdf direction and bsdf component
+	SAMPLER_BOUNCE_DIMENSIONS = 8
+)
+
+// Provides the random numbers of a sample, one dimension after the other.
+// Low discrepancy samplers distribute the samples of a pixel evenly in each dimension, which lowers the noise compared to independent random numbers
+type Sampler interface {
+	// Starts the sample with index of pixel x,y at the first dimension
+	startPixelSample(x, y, index int)
+	// Continues at the dimensions reserved for the bounce at depth, so that all samples of a pixel use the same dimensions for it
+	startBounce(depth int)
+	get1D() float64
+	get2D() (float64, float64)
+	// Returns a sampler for a single worker, which draws the random numbers it needs from r.
+	// spp is the number of samples per pixel, seed the seed of the render
+	clone(r *rand.Rand, spp int, seed uint32) Sampler
+}
+
+// Index of the next dimension of a sample
+type sampleDimensions struct {
+	dimension int
+}
+
+func (d *sampleDimensions) startBounce(depth int) {
+	d.dimension = SAMPLER_CAMERA_DIMENSIONS + depth*SAMPLER_BOUNCE_DIMENSIONS
+}
+
+// Returns the first of the next n dimensions
+func (d *sampleDimensions) next(n int) uint32 {
+	dimension := d.dimension
+	d.dimension += n
+	return uint32(dimension)
+}
+
+// Seed of pixel x,y in a render with seed
+func pixelSeed(x, y int, seed uint32) uint32 {
+	return mixSeed(hash32(uint32(x)*0x8da6b343^uint32(y)*0xd8163841), seed)
+}
+
+func mixSeed(seed, value uint32) uint32 {
+	return hash32(seed ^ hash32(value+0x9e3779b9))
+}
+
+// Independent uniform random numbers
+type independentSampler struct {
+	r *rand.Rand
+}
+
+func NewIndependentSampler() Sampler {
+	return &independentSampler{}
+}
+
+func (s *independentSampler) startPixelSample(x, y, index int) {}
+
+func (s *independentSampler) startBounce(depth int) {}
+
+func (s *independentSampler) get1D() float64 {
+	return s.r.Float64()
+}
+
+func (s *independentSampler) get2D() (float64, float64) {
+	return s.r.Float64(), s.r.Float64()
+}
+
+func (s *independentSampler) clone(r *rand.Rand, spp int, seed uint32) Sampler {
+	return &independentSampler{r: r}
+}
+
+// Jittered samples within strata, which are visited in a random order per pixel and dimension.
+// 1D dimensions are split into spp strata, 2D dimensions into a grid of at least spp cells
+type stratifiedSampler struct {
+	sampleDimensions
+	r          *rand.Rand
+	spp        int
+	renderSeed uint32
+	seed       uint32
+	index      int
+}
+
+func NewStratifiedSampler() Sampler {
+	return &stratifiedSampler{}
+}
+
+func (s *stratifiedSampler) startPixelSample(x, y, index int) {
+	s.seed = pixelSeed(x, y, s.renderSeed)
+	s.index = index
+	s.dimension = 0
+}
+
+func (s *stratifiedSampler) get1D() float64 {
+	n := uint32(s.spp)
+	stratum := permute(uint32(s.index)%n, n, mixSeed(s.seed, s.next(1)))
+	return (float64(stratum) + s.r.Float64()) / float64(n)
+}
+
+func (s *stratifiedSampler) get2D() (float64, float64) {
+	resolution := uint32(math.Ceil(math.Sqrt(float64(s.spp))))
+	n := resolution * resolution
+	cell := permute(uint32(s.index)%n, n, mixSeed(s.seed, s.next(2)))
+	x := (float64(cell%resolution) + s.r.Float64()) / float64(resolution)
+	y := (float64(cell/resolution) + s.r.Float64()) / float64(resolution)
+	return x, y
+}
+
+func (s *stratifiedSampler) clone(r *rand.Rand, spp int, seed uint32) Sampler {
+	if spp < 1 {
+		spp = 1
+	}
+	return &stratifiedSampler{r: r, spp: spp, renderSeed: seed}
+}
+
+// Halton sequence with a prime base per dimension. The digits are scrambled per pixel to decorrelate neighbouring pixels.
+// Dimensions beyond the available primes fall back to independent random numbers
+type haltonSampler struct {
+	sampleDimensions
+	r          *rand.Rand
+	renderSeed uint32
+	seed       uint32
+	index      int
+}
+
+var haltonPrimes = [...]uint32{
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131,
+	137, 139, 149, 151, 157, 163, 167, 173, 179, 181, 191, 193, 197, 199, 211, 223, 227, 229, 233, 239, 241, 251, 257, 263, 269, 271, 277, 281, 283, 293, 307, 311,
+}
+
+func NewHaltonSampler() Sampler {
+	return &haltonSampler{}
+}
+
+func (s *haltonSampler) startPixelSample(x, y, index int) {
+	s.seed = pixelSeed(x, y, s.renderSeed)
+	s.index = index
+	s.dimension = 0
+}
+
+func (s *haltonSampler) get1D() float64 {
+	return s.sample(s.next(1))
+}
+
+func (s *haltonSampler) get2D() (float64, float64) {
+	dimension := s.next(2)
+	return s.sample(dimension), s.sample(dimension + 1)
+}
+
+func (s *haltonSampler) sample(dimension uint32) float64 {
+	if int(dimension) >= len(haltonPrimes) {
+		return s.r.Float64()
+	}
+	return scrambledRadicalInverse(haltonPrimes[dimension], uint64(s.index), mixSeed(s.seed, dimension))
+}
+
+func (s *haltonSampler) clone(r *rand.Rand, spp int, seed uint32) Sampler {
+	return &haltonSampler{r: r, renderSeed: seed}
+}
+
+// Mirrors the digits of index in base at the decimal point, each digit is permuted by a random permutation derived from seed and its position
+func scrambledRadicalInverse(base uint32, index uint64, seed uint32) float64 {
+	inverseBase := 1 / float64(base)
+	scale := inverseBase
+	value := 0.0
+	// Leading zeros are scrambled too, until the digits fall below double precision
+	for position := uint32(0); scale > 1e-16; position++ {
+		digit := uint32(index % uint64(base))
+		index /= uint64(base)
+		value += float64(permute(digit, base, mixSeed(seed, position))) * scale
+		scale *= inverseBase
+	}
+	return math.Min(value, ONE_MINUS_EPSILON)
+}
+
+// Owen scrambled Sobol sequence, following Burley, "Practical Hash-based Owen Scrambling".
+// Every pair of dimensions uses the first two Sobol dimensions with its own shuffled index and scrambling
+type sobolSampler struct {
+	sampleDimensions
+	renderSeed uint32
+	seed       uint32
+	index      uint32
+}
+
+func NewSobolSampler() Sampler {
+	return &sobolSampler{}
+}
+
+func (s *sobolSampler) startPixelSample(x, y, index int) {
+	s.seed = pixelSeed(x, y, s.renderSeed)
+	s.index = uint32(index)
+	s.dimension = 0
+}
+
+func (s *sobolSampler) get1D() float64 {
+	seed := mixSeed(s.seed, s.next(1))
+	index := nestedUniformScramble(s.index, seed)
+	return sobolFloat(nestedUniformScramble(sobol(index, 0), hash32(seed)))
+}
+
+func (s *sobolSampler) get2D() (float64, float64) {
+	seed := mixSeed(s.seed, s.next(2))
+	index := nestedUniformScramble(s.index, seed)
+	x := nestedUniformScramble(sobol(index, 0), hash32(seed))
+	y := nestedUniformScramble(sobol(index, 1), hash32(seed+1))
+	return sobolFloat(x), sobolFloat(y)
+}
+
+func (s *sobolSampler) clone(r *rand.Rand, spp int, seed uint32) Sampler {
+	return &sobolSampler{renderSeed: seed}
+}
+
+// Largest float64 below 1
+const ONE_MINUS_EPSILON = 0x1.fffffffffffffp-1
+
+func sobolFloat(x uint32) float64 {
+	return math.Min(float64(x)/(1<<32), ONE_MINUS_EPSILON)
+}
+
+// Direction numbers of the second Sobol dimension, the first one reverses the bits of the index
+var sobolDirections = func() [32]uint32 {
+	var directions [32]uint32
+	v := uint32(1 << 31)
+	for i := range directions {
+		directions[i] = v
+		v ^= v >> 1
+	}
+	return directions
+}()
+
+// Sample index of Sobol dimension 0 or 1 as fixed point fraction
+func sobol(index uint32, dimension int) uint32 {
+	if dimension == 0 {
+		return bits.Reverse32(index)
+	}
+	x := uint32(0)
+	for bit := 0; index != 0; bit++ {
+		if index&1 != 0 {
+			x ^= sobolDirections[bit]
+		}
+		index >>= 1
+	}
+	return x
+}
+
+// Owen scrambling of the fixed point fraction x
+func nestedUniformScramble(x, seed uint32) uint32 {
+	x = bits.Reverse32(x)
+	x = laineKarrasPermutation(x, seed)
+	return bits.Reverse32(x)
+}
+
+// Hash that only lets bits influence higher bits
+func laineKarrasPermutation(x, seed uint32) uint32 {
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return x
+}
+
+// Returns the element at index i of a random permutation of [0,n) given by seed, see Kensler, "Correlated Multi-Jittered Sampling"
+func permute(i, n, seed uint32) uint32 {
+	w := n - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+	for {
+		i ^= seed
+		i *= 0xe170893d
+		i ^= seed >> 16
+		i ^= (i & w) >> 4
+		i ^= seed >> 8
+		i *= 0x0929eb3f
+		i ^= seed >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | seed>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		if i < n {
+			break
+		}
+	}
+	return (i + seed%n) % n
+}
+
+// Maps a uniform point of the unit square to a uniform direction on the unit sphere
+func sampleUnitSphere(u, v float64) Vector3 {
+	z := 1 - 2*u
+	r := math.Sqrt(math.Max(0, 1-z*z))
+	phi := 2 * math.Pi * v
+	return NewVector3(r*math.Cos(phi), r*math.Sin(phi), z)
+}
+
+// Random source of a render worker, which is cheap enough to be reseeded for every pixel sample.
+// Every sample thereby draws from its own stream, independent of the worker rendering it
+type sampleSource struct {
+	state uint64
+}
+
+func (s *sampleSource) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+// SplitMix64 generator
+func (s *sampleSource) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	return mix64(s.state)
+}
+
+func (s *sampleSource) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Finalizer of SplitMix64
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Seed of the random stream of the sample with index of pixel x,y in a render with seed
+func sampleSeed(seed int64, x, y, index int) int64 {
+	h := mix64(uint64(seed))
+	h = mix64(h ^ uint64(x))
+	h = mix64(h ^ uint64(y)<<32)
+	return int64(mix64(h ^ uint64(index)))
+}
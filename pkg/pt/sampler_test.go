@@ -0,0 +1,157 @@
+package pt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPermute(t *testing.T) {
+	for _, n := range []uint32{1, 2, 5, 16, 100, 1000} {
+		for _, seed := range []uint32{0, 1, 0xdeadbeef} {
+			seen := make([]bool, n)
+			for i := uint32(0); i < n; i++ {
+				p := permute(i, n, seed)
+				if p >= n || seen[p] {
+					t.Fatalf("permutation of %v elements with seed %v is not a bijection, %v maps to %v", n, seed, i, p)
+				}
+				seen[p] = true
+			}
+		}
+	}
+}
+
+func TestSamplerRange(t *testing.T) {
+	for name, newSampler := range seedTestSamplers {
+		s := newSampler().clone(rand.New(rand.NewSource(1)), 16, 7)
+		for _, pixel := range [][2]int{{0, 0}, {5, 9}, {1000, 3}} {
+			for index := 0; index < 64; index++ {
+				s.startPixelSample(pixel[0], pixel[1], index)
+				// Beyond the dimensions of the Halton primes
+				for d := 0; d < 100; d++ {
+					x, y := s.get2D()
+					v := s.get1D()
+					if x < 0 || x >= 1 || y < 0 || y >= 1 || v < 0 || v >= 1 {
+						t.Fatalf("%v: sample %v of pixel %v returned %v, %v and %v", name, index, pixel, x, y, v)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSamplerStratification(t *testing.T) {
+	tests := []struct {
+		sampler   string
+		spp       int
+		dimension int // of the 1D samples
+		twoD      bool
+	}{
+		{"stratified", 64, 0, true},
+		{"stratified", 64, 3, true},
+		{"stratified", 10, 0, false},
+		{"halton", 64, 0, false}, // base 2
+		{"halton", 81, 1, false}, // base 3
+		{"halton", 125, 2, false},
+		{"sobol", 64, 0, true},
+		{"sobol", 64, 7, true},
+		{"sobol", 256, 3, false},
+	}
+	for _, test := range tests {
+		s := seedTestSamplers[test.sampler]().clone(rand.New(rand.NewSource(1)), test.spp, 3)
+		for _, pixel := range [][2]int{{0, 0}, {17, 4}} {
+			strata := make([]int, test.spp)
+			for index := 0; index < test.spp; index++ {
+				s.startPixelSample(pixel[0], pixel[1], index)
+				stratum := 0
+				if test.twoD {
+					// Dimensions of the camera and the bounces are all used as 2D dimensions here
+					for d := 0; d < test.dimension; d++ {
+						s.get2D()
+					}
+					x, y := s.get2D()
+					resolution := 8
+					stratum = int(y*float64(resolution))*resolution + int(x*float64(resolution))
+				} else {
+					for d := 0; d < test.dimension; d++ {
+						s.get1D()
+					}
+					stratum = int(s.get1D() * float64(test.spp))
+				}
+				strata[stratum]++
+			}
+			for i, n := range strata {
+				if n != 1 {
+					t.Errorf("%v with %v samples, dimension %v of pixel %v: stratum %v has %v samples", test.sampler, test.spp, test.dimension, pixel, i, n)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestSamplerDeterminism(t *testing.T) {
+	// Workers reseed their random source for every sample like ImageRenderer.RenderContext
+	type worker struct {
+		sampler Sampler
+		source  *sampleSource
+	}
+	newWorker := func(newSampler func() Sampler, seed uint32) worker {
+		source := &sampleSource{}
+		return worker{newSampler().clone(rand.New(source), 16, seed), source}
+	}
+	values := func(w worker, x, y, index, depth int) [4]float64 {
+		w.source.Seed(sampleSeed(1, x, y, index))
+		w.sampler.startPixelSample(x, y, index)
+		w.sampler.startBounce(depth)
+		a, b := w.sampler.get2D()
+		return [4]float64{a, b, w.sampler.get1D(), w.sampler.get1D()}
+	}
+	for name, newSampler := range seedTestSamplers {
+		w := newWorker(newSampler, 7)
+		reference := values(w, 3, 4, 5, 1)
+		// Other pixels and bounces visited in between do not change the values of a sample
+		values(w, 9, 9, 0, 0)
+		values(w, 3, 4, 5, 2)
+		if got := values(w, 3, 4, 5, 1); got != reference {
+			t.Errorf("%v: repeated sample returned %v, expected %v", name, got, reference)
+		}
+		if got := values(newWorker(newSampler, 7), 3, 4, 5, 1); got != reference {
+			t.Errorf("%v: sample of another worker returned %v, expected %v", name, got, reference)
+		}
+		others := [][4]float64{values(w, 4, 4, 5, 1), values(w, 3, 5, 5, 1), values(w, 3, 4, 6, 1)}
+		if name != "independent" {
+			// The independent sampler only depends on the random source, which is seeded per sample by the caller
+			others = append(others, values(w, 3, 4, 5, 0), values(newWorker(newSampler, 8), 3, 4, 5, 1))
+		}
+		for _, got := range others {
+			if got == reference {
+				t.Errorf("%v: samples of different pixels, indices, bounces or seeds are equal", name)
+			}
+		}
+	}
+}
+
+func TestSampleSeed(t *testing.T) {
+	seen := map[int64]bool{}
+	for seed := int64(0); seed < 3; seed++ {
+		for x := 0; x < 8; x++ {
+			for y := 0; y < 8; y++ {
+				for index := 0; index < 8; index++ {
+					s := sampleSeed(seed, x, y, index)
+					if seen[s] {
+						t.Fatalf("seed %v of pixel %v,%v and sample %v is not unique", s, x, y, index)
+					}
+					seen[s] = true
+				}
+			}
+		}
+	}
+	a, b := &sampleSource{}, &sampleSource{}
+	a.Seed(sampleSeed(1, 2, 3, 4))
+	b.Seed(sampleSeed(1, 2, 3, 4))
+	for i := 0; i < 100; i++ {
+		if a.Int63() != b.Int63() {
+			t.Fatal("sources with the same seed diverged")
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package pt
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Spatial splits are only tried if the children of the best object split overlap by more than this fraction of the root surface
+	DEFAULT_SBVH_ALPHA = 1e-5
+	// Fraction of additional references that may be created by spatial splits
+	DEFAULT_SBVH_DUPLICATION = 0.3
+)
+
+// Split BVH builder (Stich et al. 2009). In addition to binned SAH object splits, nodes can be split spatially,
+// which duplicates references to primitives that straddle the split plane and clips their bounding boxes.
+// Leaves may therefore share primitives
+type SBVHBuilder struct {
+	Bins            int
+	LeafSize        int
+	BranchingFactor int
+	Alpha           float64 // overlap threshold relative to the root surface
+	Duplication     float64 // memory budget: at most Duplication * primitive count references are added
+	threadCount     int
+	primitives      []tracable
+	rootSurface     float64
+	budget          int64 // remaining references that may be duplicated
+	workers         chan struct{}
+}
+
+// Reference to a primitive with a possibly clipped bounding box
+type sbvhRef struct {
+	index    int
+	bounding aabb
+}
+
+type sbvhSet struct {
+	refs     []sbvhRef
+	bounding aabb
+}
+
+func NewDefaultSBVHBuilder(primitives []tracable) SBVHBuilder {
+	return NewSBVHBuilder(primitives, DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, DEFAULT_SBVH_DUPLICATION, runtime.GOMAXPROCS(0))
+}
+
+// Bins, leafSize and threadCount below 1, a branchingFactor below 2 and a negative duplication are raised to these minimums when building
+func NewSBVHBuilder(primitives []tracable, bins, leafSize, branchingFactor int, duplication float64, threadCount int) SBVHBuilder {
+	return SBVHBuilder{
+		Bins:            bins,
+		LeafSize:        leafSize,
+		BranchingFactor: branchingFactor,
+		Alpha:           DEFAULT_SBVH_ALPHA,
+		Duplication:     duplication,
+		threadCount:     threadCount,
+		primitives:      primitives,
+	}
+}
+
+// Raises parameters the builder can not terminate with to their minimum
+func (b *SBVHBuilder) clamp() {
+	b.Bins = atLeast(b.Bins, 1)
+	b.LeafSize = atLeast(b.LeafSize, 1)
+	b.BranchingFactor = atLeast(b.BranchingFactor, 2)
+	b.threadCount = atLeast(b.threadCount, 1)
+	if !(b.Duplication >= 0) {
+		b.Duplication = 0
+	}
+}
+
+func (b *SBVHBuilder) Build() BVH {
+	b.clamp()
+	if len(b.primitives) == 0 {
+		return emptyBVH()
+	}
+	refs := make([]sbvhRef, len(b.primitives))
+	for i, prim := range b.primitives {
+		refs[i] = sbvhRef{i, prim.bounding()}
+	}
+	root := sbvhSet{refs, enclosingRefs(refs)}
+	b.rootSurface = root.bounding.surface()
+	b.budget = int64(b.Duplication * float64(len(b.primitives)))
+	b.workers = make(chan struct{}, b.threadCount-1)
+
+	temp := newBranch(1)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	b.buildSubTree(root, temp, 0, &wg)
+	wg.Wait()
+
+	node := temp.children[0]
+	node.parent = nil
+	return BVH{
+		root:  node,
+		prims: b.primitives,
+	}
+}
+
+// Number of references that were duplicated by spatial splits during the last build
+func (b *SBVHBuilder) Duplicated() int {
+	return int(b.Duplication*float64(len(b.primitives))) - int(atomic.LoadInt64(&b.budget))
+}
+
+func (b *SBVHBuilder) buildSubTree(set sbvhSet, parent *bvhNode, childIndex int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if len(set.refs) <= b.LeafSize {
+		prims := make([]int, len(set.refs))
+		for i, ref := range set.refs {
+			prims[i] = ref.index
+		}
+		leaf := newLeaf(prims)
+		leaf.bounding = set.bounding
+		parent.addChild(leaf, childIndex)
+		return
+	}
+
+	sets := make([]sbvhSet, 1, b.BranchingFactor)
+	sets[0] = set
+	for len(sets) < b.BranchingFactor {
+		max := 0
+		maxI := 0
+		for i, s := range sets {
+			if len(s.refs) > max {
+				max = len(s.refs)
+				maxI = i
+			}
+		}
+		if max <= b.LeafSize {
+			break
+		}
+		left, right := b.split(sets[maxI])
+		sets[maxI] = left
+		sets = append(sets, right)
+	}
+
+	branch := newBranch(len(sets))
+	branch.bounding = set.bounding
+	parent.addChild(branch, childIndex)
+
+	wg.Add(len(sets))
+	for i, s := range sets {
+		if len(s.refs) < SAH_PARALLEL_THRESHOLD {
+			b.buildSubTree(s, branch, i, wg)
+			continue
+		}
+		select {
+		case b.workers <- struct{}{}:
+			go func(s sbvhSet, i int) {
+				b.buildSubTree(s, branch, i, wg)
+				<-b.workers
+			}(s, i)
+		default:
+			b.buildSubTree(s, branch, i, wg)
+		}
+	}
+}
+
+// Splits the set using the cheaper of the best object split and the best spatial split
+func (b *SBVHBuilder) split(set sbvhSet) (sbvhSet, sbvhSet) {
+	objectCost, objectAxis, objectBin, centroidMin, centroidMax := b.findObjectSplit(set)
+	if objectAxis < 0 {
+		// All centroids coincide, object splits can not separate the references. Spatial splits are preferred over the median if cheaper
+		mid := len(set.refs) / 2
+		medianLeft, medianRight := newSBVHSet(set.refs[:mid]), newSBVHSet(set.refs[mid:])
+		if atomic.LoadInt64(&b.budget) <= 0 {
+			return medianLeft, medianRight
+		}
+		medianCost := medianLeft.bounding.surface()*float64(len(medianLeft.refs)) + medianRight.bounding.surface()*float64(len(medianRight.refs))
+		spatialCost, spatialAxis, position := b.findSpatialSplit(set)
+		if spatialAxis < 0 || spatialCost >= medianCost {
+			return medianLeft, medianRight
+		}
+		if left, right, ok := b.partitionSpatial(set, spatialAxis, position); ok {
+			return left, right
+		}
+		return medianLeft, medianRight
+	}
+
+	objectLeft, objectRight := b.partitionObjects(set, objectAxis, objectBin, centroidMin, centroidMax)
+	overlap, overlaps := intersection(objectLeft.bounding, objectRight.bounding)
+	if !overlaps || overlap.surface() <= b.Alpha*b.rootSurface || atomic.LoadInt64(&b.budget) <= 0 {
+		return objectLeft, objectRight
+	}
+
+	spatialCost, spatialAxis, position := b.findSpatialSplit(set)
+	if spatialAxis < 0 || spatialCost >= objectCost {
+		return objectLeft, objectRight
+	}
+	if left, right, ok := b.partitionSpatial(set, spatialAxis, position); ok {
+		return left, right
+	}
+	return objectLeft, objectRight
+}
+
+// Returns the SAH cost, axis and bin of the best object split, axis is -1 if no split was found
+func (b *SBVHBuilder) findObjectSplit(set sbvhSet) (float64, int, int, Vector3, Vector3) {
+	centroidMin := set.refs[0].bounding.barycenter
+	centroidMax := centroidMin
+	for _, ref := range set.refs[1:] {
+		centroidMin = MinVec(centroidMin, ref.bounding.barycenter)
+		centroidMax = MaxVec(centroidMax, ref.bounding.barycenter)
+	}
+
+	bestCost := math.Inf(1)
+	bestAxis := -1
+	bestBin := 0
+	bins := make([]sahBin, b.Bins)
+	rightCosts := make([]float64, b.Bins)
+	for axis := 0; axis < 3; axis++ {
+		min := component(centroidMin, axis)
+		extent := component(centroidMax, axis) - min
+		if extent <= 0 {
+			continue
+		}
+		for i := range bins {
+			bins[i] = sahBin{}
+		}
+		for _, ref := range set.refs {
+			bin := binIndex(b.Bins, component(ref.bounding.barycenter, axis), min, extent)
+			bins[bin].bounding, bins[bin].count = extendBin(bins[bin].bounding, bins[bin].count, sahBin{ref.bounding, 1})
+		}
+		cost, bin := sweepBins(bins, rightCosts, len(set.refs))
+		if cost < bestCost {
+			bestCost = cost
+			bestAxis = axis
+			bestBin = bin
+		}
+	}
+	return bestCost, bestAxis, bestBin, centroidMin, centroidMax
+}
+
+func (b *SBVHBuilder) partitionObjects(set sbvhSet, axis, bin int, centroidMin, centroidMax Vector3) (sbvhSet, sbvhSet) {
+	min := component(centroidMin, axis)
+	extent := component(centroidMax, axis) - min
+	left := make([]sbvhRef, 0, len(set.refs))
+	right := make([]sbvhRef, 0, len(set.refs))
+	for _, ref := range set.refs {
+		if binIndex(b.Bins, component(ref.bounding.barycenter, axis), min, extent) <= bin {
+			left = append(left, ref)
+		} else {
+			right = append(right, ref)
+		}
+	}
+	return newSBVHSet(left), newSBVHSet(right)
+}
+
+// Spatial bins track the clipped bounding boxes of all references overlapping them
+// and count references entering and exiting through the bin
+type sbvhBin struct {
+	sahBin
+	entries int
+	exits   int
+}
+
+// Returns the SAH cost, axis and plane position of the best spatial split, axis is -1 if no split was found
+func (b *SBVHBuilder) findSpatialSplit(set sbvhSet) (float64, int, float64) {
+	bestCost := math.Inf(1)
+	bestAxis := -1
+	bestPosition := 0.0
+	bins := make([]sbvhBin, b.Bins)
+	rightCosts := make([]float64, b.Bins)
+	for axis := 0; axis < 3; axis++ {
+		min := component(set.bounding.bounds[0], axis)
+		extent := component(set.bounding.bounds[1], axis) - min
+		if extent <= 0 {
+			continue
+		}
+		binWidth := extent / float64(b.Bins)
+		for i := range bins {
+			bins[i] = sbvhBin{}
+		}
+		for _, ref := range set.refs {
+			first := binIndex(b.Bins, component(ref.bounding.bounds[0], axis), min, extent)
+			last := binIndex(b.Bins, component(ref.bounding.bounds[1], axis), min, extent)
+			for i := first; i <= last; i++ {
+				lo := min + float64(i)*binWidth
+				if clipped, ok := b.clip(ref, axis, lo, lo+binWidth); ok {
+					bins[i].bounding, bins[i].count = extendBin(bins[i].bounding, bins[i].count, sahBin{clipped, 1})
+				}
+			}
+			bins[first].entries++
+			bins[last].exits++
+		}
+
+		var right aabb
+		rightPieces := 0
+		rightCount := 0
+		for i := b.Bins - 1; i > 0; i-- {
+			right, rightPieces = extendBin(right, rightPieces, bins[i].sahBin)
+			rightCount += bins[i].exits
+			rightCosts[i] = right.surface() * float64(rightCount)
+		}
+		var left aabb
+		leftPieces := 0
+		leftCount := 0
+		exited := 0
+		for i := 0; i < b.Bins-1; i++ {
+			left, leftPieces = extendBin(left, leftPieces, bins[i].sahBin)
+			leftCount += bins[i].entries
+			exited += bins[i].exits
+			if leftCount == 0 || exited == len(set.refs) {
+				continue
+			}
+			cost := left.surface()*float64(leftCount) + rightCosts[i+1]
+			if cost < bestCost {
+				bestCost = cost
+				bestAxis = axis
+				bestPosition = min + float64(i+1)*binWidth
+			}
+		}
+	}
+	return bestCost, bestAxis, bestPosition
+}
+
+// Splits the references at position, straddling references are clipped and added to both sides.
+// Fails if the duplication budget is exceeded or one side would be empty
+func (b *SBVHBuilder) partitionSpatial(set sbvhSet, axis int, position float64) (sbvhSet, sbvhSet, bool) {
+	left := make([]sbvhRef, 0, len(set.refs))
+	right := make([]sbvhRef, 0, len(set.refs))
+	duplicates := int64(0)
+	for _, ref := range set.refs {
+		lo := component(ref.bounding.bounds[0], axis)
+		hi := component(ref.bounding.bounds[1], axis)
+		switch {
+		case hi <= position:
+			left = append(left, ref)
+		case lo >= position:
+			right = append(right, ref)
+		default:
+			l, okLeft := b.clip(ref, axis, math.Inf(-1), position)
+			r, okRight := b.clip(ref, axis, position, math.Inf(1))
+			if okLeft {
+				left = append(left, sbvhRef{ref.index, l})
+			}
+			if okRight {
+				right = append(right, sbvhRef{ref.index, r})
+			}
+			if okLeft && okRight {
+				duplicates++
+			}
+		}
+	}
+	if len(left) == 0 || len(right) == 0 {
+		return sbvhSet{}, sbvhSet{}, false
+	}
+	if atomic.AddInt64(&b.budget, -duplicates) < 0 {
+		atomic.AddInt64(&b.budget, duplicates)
+		return sbvhSet{}, sbvhSet{}, false
+	}
+	return newSBVHSet(left), newSBVHSet(right), true
+}
+
+// Bounding box of the part of the referenced primitive inside the slab [lo,hi] along axis.
+// Triangles are clipped exactly, other primitives by their bounding box
+func (b *SBVHBuilder) clip(ref sbvhRef, axis int, lo, hi float64) (aabb, bool) {
+	slab := ref.bounding
+	slab.bounds[0] = withComponent(slab.bounds[0], axis, math.Max(lo, component(slab.bounds[0], axis)))
+	slab.bounds[1] = withComponent(slab.bounds[1], axis, math.Min(hi, component(slab.bounds[1], axis)))
+	if component(slab.bounds[0], axis) > component(slab.bounds[1], axis) {
+		return aabb{}, false
+	}
+	slab.update()
+
+	tri, ok := b.primitives[ref.index].prim.(*Triangle)
+	if !ok {
+		return slab, true
+	}
+	points := make([]Vector3, 0, 9)
+	for i := 0; i < 3; i++ {
+		a := tri.vertecies[i].position
+		c := tri.vertecies[(i+1)%3].position
+		va := component(a, axis)
+		vc := component(c, axis)
+		if va >= lo && va <= hi {
+			points = append(points, a)
+		}
+		// Intersections of the edge with the slab planes
+		for _, plane := range []float64{lo, hi} {
+			if (va < plane && vc > plane) || (va > plane && vc < plane) {
+				t := (plane - va) / (vc - va)
+				points = append(points, a.Add(c.Sub(a).Mul(t)))
+			}
+		}
+	}
+	if len(points) == 0 {
+		return aabb{}, false
+	}
+	min, max := points[0], points[0]
+	for _, p := range points[1:] {
+		min = MinVec(min, p)
+		max = MaxVec(max, p)
+	}
+	clipped, ok := intersection(newAABB(min, max), slab)
+	if !ok {
+		// Degenerate due to rounding, fall back to the slab
+		return slab, true
+	}
+	return clipped, true
+}
+
+func newSBVHSet(refs []sbvhRef) sbvhSet {
+	return sbvhSet{refs, enclosingRefs(refs)}
+}
+
+func enclosingRefs(refs []sbvhRef) aabb {
+	enclosing := refs[0].bounding
+	for _, ref := range refs[1:] {
+		enclosing = enclosing.add(ref.bounding)
+	}
+	return enclosing
+}
+
+// Overlap of a and b, false if they are disjoint
+func intersection(a, b aabb) (aabb, bool) {
+	min := MaxVec(a.bounds[0], b.bounds[0])
+	max := MinVec(a.bounds[1], b.bounds[1])
+	if min.X > max.X || min.Y > max.Y || min.Z > max.Z {
+		return aabb{}, false
+	}
+	return newAABB(min, max), true
+}
+
+func withComponent(v Vector3, axis int, value float64) Vector3 {
+	switch axis {
+	case 0:
+		v.X = value
+	case 1:
+		v.Y = value
+	default:
+		v.Z = value
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Parallel long thin diagonal triangles, whose bounding boxes overlap so much that spatial splits pay off
+func diagonalTestScene() *Scene {
+	scene := NewScene()
+	geometry := Geometry{}
+	for i := 0; i < 200; i++ {
+		offset := NewVector3(1, -1, 0).Mul(float64(i)*0.1 - 10)
+		start := NewVector3(-10, -10, 0).Add(offset)
+		end := NewVector3(10, 10, 0).Add(offset)
+		geometry = append(geometry, NewTriangleWithoutNormals(start, end, end.Add(NewVector3(0.05, -0.05, 0))))
+	}
+	scene.Add(NewSceneNode(NewMesh(geometry, Diffuse{})))
+	return scene
+}
+
+// Long thin triangles crossing each other with the centers of their bounding boxes at the origin, object splits can not separate them.
+// The triangles are tilted by different angles, so that no two of them are hit at the same distance
+func fanTestScene() *Scene {
+	scene := NewScene()
+	geometry := Geometry{}
+	for i := 0; i < 200; i++ {
+		// Away from the axes the short side stays within the bounding box of the long side
+		angle := math.Pi * (0.05 + 0.4*float64(i%100)/100)
+		if i >= 100 {
+			angle += math.Pi / 2
+		}
+		end := NewVector3(10*math.Cos(angle), 10*math.Sin(angle), float64(i)*0.01)
+		width := NewVector3(-math.Sin(angle), math.Cos(angle), 0).Mul(0.05)
+		geometry = append(geometry, NewTriangleWithoutNormals(end, end.Mul(-1), width))
+	}
+	scene.Add(NewSceneNode(NewMesh(geometry, Diffuse{})))
+	return scene
+}
+
+func TestSBVHBuilder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name                            string
+		scene                           *Scene
+		bins, leafSize, branchingFactor int
+		duplication                     float64
+		spatialSplits                   bool
+	}{
+		{"default", bvhTestScene(r), DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, DEFAULT_SBVH_DUPLICATION, false},
+		{"diagonal", diagonalTestScene(), DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, DEFAULT_SBVH_DUPLICATION, true},
+		{"diagonal wide", diagonalTestScene(), 8, 2, 4, 1, true},
+		{"no duplication", diagonalTestScene(), DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, 0, false},
+		{"clamped", diagonalTestScene(), 0, 0, 0, math.NaN(), false},
+		{"coincident centroids", fanTestScene(), DEFAULT_SAH_BINS, 1, 2, DEFAULT_SBVH_DUPLICATION, true},
+	}
+	for _, test := range tests {
+		reference := test.scene.Compile()
+		prims := test.scene.collectTracables(false)
+		builder := NewSBVHBuilder(prims, test.bins, test.leafSize, test.branchingFactor, test.duplication, 4)
+		bvh := builder.Build()
+		checkBVHStructure(t, test.name, &bvh, builder.BranchingFactor, true)
+		duplicated := builder.Duplicated()
+		if duplicated < 0 || float64(duplicated) > builder.Duplication*float64(len(prims)) {
+			t.Errorf("%v: %v references duplicated with a budget of %v", test.name, duplicated, builder.Duplication)
+		}
+		if test.spatialSplits && duplicated == 0 {
+			t.Errorf("%v: no spatial splits", test.name)
+		}
+		rays := make([]ray, 2000)
+		for i := range rays {
+			origin := NewVector3(r.Float64()*24-12, r.Float64()*24-12, 5)
+			rays[i] = newRay(origin, NewVector3(r.Float64()-0.5, r.Float64()-0.5, -1))
+		}
+		rays = append(rays, bvhTestRays(r, 1000)...)
+		expectSameHits(t, test.name, &reference, &bvh, rays)
+	}
+}
+
+func TestSBVHBuilderEmpty(t *testing.T) {
+	builder := NewSBVHBuilder(nil, DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, DEFAULT_SBVH_DUPLICATION, 2)
+	bvh := builder.Build()
+	if _, ok := bvh.Intersect(NewVector3(0, 0, 5), NewVector3(0, 0, -1), 0, 10); ok {
+		t.Error("empty BVH was hit")
+	}
+}
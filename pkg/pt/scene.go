@@ -1,6 +1,7 @@
 package pt
 
 import (
+	goreflect "reflect"
 	"runtime"
 )
 
@@ -19,30 +20,114 @@ func (s *Scene) Add(node *SceneNode) {
 }
 
 func (s *Scene) Compile() BVH {
-	prims := s.root.collectTracables(IdentityMatrix())
+	prims := s.collectTracables(false)
 	builder := NewDefaultBuilder(prims)
-	return builder.Build()
+	bvh := builder.Build()
+	s.compiled(&bvh)
+	return bvh
 }
 
 func (s *Scene) CompileLBVH() BVH {
-	prims := s.root.collectTracables(IdentityMatrix())
-	return DefaultLBVH(prims)
+	prims := s.collectTracables(false)
+	bvh := DefaultLBVH(prims)
+	s.compiled(&bvh)
+	return bvh
 }
 
 func (s *Scene) CompilePHR(alpha, delta float64, branchingFactor int) BVH {
-	prims := s.root.collectTracables(IdentityMatrix())
+	prims := s.collectTracables(false)
 	builder := NewPHRBuilder(prims, alpha, delta, branchingFactor, runtime.GOMAXPROCS(0))
-	return builder.Build()
+	bvh := builder.Build()
+	s.compiled(&bvh)
+	return bvh
+}
+
+// Builds the BVH top down using binned SAH, see SAHBuilder. Invalid parameters are clamped, see NewSAHBuilder
+func (s *Scene) CompileSAH(bins, leafSize, branchingFactor int) BVH {
+	prims := s.collectTracables(false)
+	builder := NewSAHBuilder(prims, bins, leafSize, branchingFactor, runtime.GOMAXPROCS(0))
+	bvh := builder.Build()
+	s.compiled(&bvh)
+	return bvh
+}
+
+// Builds the BVH using spatial splits, duplication limits the additional references relative to the primitive count, see SBVHBuilder. Invalid parameters are clamped, see NewSBVHBuilder
+func (s *Scene) CompileSBVH(bins, leafSize, branchingFactor int, duplication float64) BVH {
+	prims := s.collectTracables(false)
+	builder := NewSBVHBuilder(prims, bins, leafSize, branchingFactor, duplication, runtime.GOMAXPROCS(0))
+	bvh := builder.Build()
+	s.compiled(&bvh)
+	return bvh
+}
+
+// Builds a two level BVH. Every mesh gets its own bottom level BVH, which is shared by all nodes using the mesh.
+// The top level BVH is built over the instances of these meshes
+func (s *Scene) CompileInstanced() BVH {
+	prims := s.collectTracables(true)
+	builder := NewDefaultBuilder(prims)
+	bvh := builder.Build()
+	bvh.instanced = true
+	s.compiled(&bvh)
+	return bvh
+}
+
+// Collects the tracables of all nodes. Moving meshes and, if instanced is set, instancable meshes are referenced through instances
+func (s *Scene) collectTracables(instanced bool) []tracable {
+	return s.root.collectTracables(IdentityMatrix(), IdentityMatrix(), instanced, make(map[*Mesh]*BVH))
+}
+
+// Prepares a freshly built bvh for rendering and refitting
+func (s *Scene) compiled(bvh *BVH) {
+	bvh.collectLights()
+	bvh.builtCost = bvh.Cost()
+	s.root.clean()
+	s.root.assignIDs(&sceneIDs{
+		materials: make(map[uintptr]int),
+		meshes:    make(map[*Mesh]bool),
+	})
+}
+
+// Updates the primitives of all nodes transformed since bvh was compiled and refits the bounding boxes bottom up in parallel.
+// The scene is rebuilt using rebuild, e.g. (*Scene).CompileLBVH, if nodes with meshes were added, nodes started or stopped moving
+// or if the cost of the refitted BVH exceeds its cost after the last build by more than the factor threshold.
+// A copy of bvh is refitted, bvh itself and BVHs sharing its nodes stay unchanged
+func (s *Scene) Refit(bvh BVH, threshold float64, rebuild func(*Scene) BVH) BVH {
+	bvh = bvh.clone()
+	if offset, ok := s.root.refit(IdentityMatrix(), IdentityMatrix(), false, &bvh, 0); !ok || offset != len(bvh.prims) {
+		return rebuild(s)
+	}
+	bvh.storeLeaves()
+	bvh.updateBounding(runtime.GOMAXPROCS(0))
+	bvh.collectLights()
+	if bvh.Cost() > bvh.builtCost*threshold {
+		return rebuild(s)
+	}
+	if bvh.wide != nil {
+		bvh.Flatten(bvh.wide.width)
+	}
+	return bvh
 }
 
 func (s *Scene) UntransformedTracables() []tracable {
 	return s.root.collectTracablesRaw()
 }
 
+// IDs handed out while compiling a scene
+type sceneIDs struct {
+	objects   int
+	materials map[uintptr]int // IDs of materials stored as pointers by their address
+	meshes    map[*Mesh]bool  // meshes with an assigned material ID
+	count     int             // number of material IDs
+}
+
 type SceneNode struct {
+	id             int // object ID, assigned when compiling
 	transformation Matrix4
+	end            Matrix4 // transformation at the end of the motion, if moving
+	moving         bool
 	children       []*SceneNode
 	mesh           *Mesh
+	dirty          bool // transformation changed since the last compile
 }
 
 func NewSceneNode(mesh *Mesh) *SceneNode {
@@ -52,6 +137,11 @@ func NewSceneNode(mesh *Mesh) *SceneNode {
 	}
 }
 
+// ID of the node in the object ID AOV, assigned to nodes with meshes when compiling the scene starting at 1
+func (n *SceneNode) ObjectID() int {
+	return n.id
+}
+
 func (n *SceneNode) Add(node *SceneNode) {
 	n.children = append(n.children, node)
 }
@@ -74,14 +164,121 @@ func (n *SceneNode) Rotate(dir Vector3, angle float64) {
 
 func (n *SceneNode) SetTransformation(t Matrix4) {
 	n.transformation = t
+	n.dirty = true
 }
 
 func (n *SceneNode) ResetTransformation() {
 	n.transformation = IdentityMatrix()
+	n.dirty = true
+}
+
+// Moves the node from its transformation at time 0 to end at time 1, which is used for motion blur, see Camera.SetShutter.
+// Points move on straight lines in between, so large rotations are distorted. Emissive moving meshes are not sampled as lights
+func (n *SceneNode) SetMotion(end Matrix4) {
+	n.end = end
+	n.moving = true
+	n.dirty = true
+}
+
+func (n *SceneNode) ClearMotion() {
+	n.moving = false
+	n.dirty = true
+}
+
+func (n *SceneNode) endTransformation() Matrix4 {
+	if n.moving {
+		return n.end
+	}
+	return n.transformation
 }
 
 func (n *SceneNode) transform(t Matrix4) {
 	n.transformation = n.transformation.MultiplyMatrix(t)
+	n.dirty = true
+}
+
+func (n *SceneNode) clean() {
+	n.dirty = false
+	for _, child := range n.children {
+		child.clean()
+	}
+}
+
+// Replaces the tracables of dirty subtrees in bvh, starting at offset in the order they were collected.
+// Returns the offset after the subtree, false if the subtree no longer matches the tracables of bvh
+func (n *SceneNode) refit(start, end Matrix4, parentDirty bool, bvh *BVH, offset int) (int, bool) {
+	start = n.transformation.MultiplyMatrix(start)
+	end = n.endTransformation().MultiplyMatrix(end)
+	dirty := parentDirty || n.dirty
+	n.dirty = false
+	if n.mesh != nil {
+		if offset >= len(bvh.prims) {
+			return offset, false
+		}
+		switch prim := bvh.prims[offset].prim.(type) {
+		case *movingInstance:
+			if start == end {
+				return offset, false
+			}
+			if dirty {
+				bvh.prims[offset].prim = newMovingInstance(prim.blas, start, end)
+			}
+			offset++
+		case *instance:
+			if start != end {
+				return offset, false
+			}
+			if dirty {
+				bvh.prims[offset].prim = newInstance(prim.blas, start)
+			}
+			offset++
+		default:
+			if start != end || (bvh.instanced && n.mesh.instancable()) || offset+len(n.mesh.geometry) > len(bvh.prims) {
+				return offset, false
+			}
+			if dirty {
+				copy(bvh.prims[offset:], n.tracables(start))
+			}
+			offset += len(n.mesh.geometry)
+		}
+	}
+	for _, child := range n.children {
+		var ok bool
+		if offset, ok = child.refit(start, end, dirty, bvh, offset); !ok {
+			return offset, false
+		}
+	}
+	return offset, true
+}
+
+// Numbers the nodes with meshes and the materials of their meshes.
+// Meshes share a material ID if they point to the same material, materials stored as values get an ID per mesh
+func (n *SceneNode) assignIDs(ids *sceneIDs) {
+	if n.mesh != nil {
+		ids.objects++
+		n.id = ids.objects
+		if !ids.meshes[n.mesh] {
+			ids.meshes[n.mesh] = true
+			n.mesh.materialID = ids.material(n.mesh.material)
+		}
+	}
+	for _, child := range n.children {
+		child.assignIDs(ids)
+	}
+}
+
+func (ids *sceneIDs) material(mat Material) int {
+	// Values are not compared, they may contain fields that can not be used as map keys
+	if v := goreflect.ValueOf(mat); mat != nil && v.Kind() == goreflect.Ptr {
+		if id, ok := ids.materials[v.Pointer()]; ok {
+			return id
+		}
+		ids.count++
+		ids.materials[v.Pointer()] = ids.count
+		return ids.count
+	}
+	ids.count++
+	return ids.count
 }
 
 // Returns all Tracables without transforming
@@ -96,19 +293,40 @@ func (n *SceneNode) collectTracablesRaw() []tracable {
 	return out
 }
 
-// Returns all Tracables transformed by t
-func (n *SceneNode) collectTracables(t Matrix4) []tracable {
-	t = n.transformation.MultiplyMatrix(t)
+// Returns all Tracables transformed by the transformations at the start and end of the motion.
+// Moving meshes are referenced through moving instances, instancable meshes through instances if instanced is set.
+// Bottom level BVHs are cached in blas
+func (n *SceneNode) collectTracables(start, end Matrix4, instanced bool, blas map[*Mesh]*BVH) []tracable {
+	start = n.transformation.MultiplyMatrix(start)
+	end = n.endTransformation().MultiplyMatrix(end)
 	out := make([]tracable, 0)
 	if n.mesh != nil {
-		if n.transformation == IdentityMatrix() {
-			out = append(out, n.mesh.raw()...)
-		} else {
-			out = append(out, n.mesh.Transformed(t)...)
+		// Instances carry no material, hits keep the material of the mesh
+		switch {
+		case start != end:
+			out = append(out, tracable{prim: newMovingInstance(n.mesh.bottomLevel(blas), start, end), node: n})
+		case instanced && n.mesh.instancable():
+			out = append(out, tracable{prim: newInstance(n.mesh.bottomLevel(blas), start), node: n})
+		default:
+			out = append(out, n.tracables(start)...)
 		}
 	}
 	for _, child := range n.children {
-		out = append(out, child.collectTracables(t)...)
+		out = append(out, child.collectTracables(start, end, instanced, blas)...)
+	}
+	return out
+}
+
+// Returns the tracables of the mesh transformed by t, referencing n as their source
+func (n *SceneNode) tracables(t Matrix4) []tracable {
+	var out []tracable
+	if t == IdentityMatrix() {
+		out = n.mesh.raw()
+	} else {
+		out = n.mesh.Transformed(t)
+	}
+	for i := range out {
+		out[i].node = n
 	}
 	return out
 }
@@ -116,8 +334,9 @@ func (n *SceneNode) collectTracables(t Matrix4) []tracable {
 type Geometry []primitive
 
 type Mesh struct {
-	geometry Geometry
-	material Material
+	geometry   Geometry
+	material   Material
+	materialID int // assigned when compiling
 }
 
 func NewMesh(geometry Geometry, mat Material) *Mesh {
@@ -127,23 +346,49 @@ func NewMesh(geometry Geometry, mat Material) *Mesh {
 	}
 }
 
+// ID of the material in the material ID AOV, assigned when compiling the scene starting at 1.
+// Meshes only share an ID if their material is the same pointer, e.g. &Diffuse{...}
+func (m *Mesh) MaterialID() int {
+	return m.materialID
+}
+
 func (m Mesh) raw() []tracable {
 	tracables := make([]tracable, len(m.geometry))
 	for i, prim := range m.geometry {
 		tracables[i] = tracable{
-			prim: prim,
-			mat:  m.material,
+			prim:  prim,
+			mat:   m.material,
+			index: i,
 		}
 	}
 	return tracables
 }
 
+// Returns the BVH over the untransformed mesh, which is built once per mesh and cached in blas
+func (m *Mesh) bottomLevel(blas map[*Mesh]*BVH) *BVH {
+	bvh, ok := blas[m]
+	if !ok {
+		builder := NewDefaultBuilder(m.raw())
+		built := builder.Build()
+		bvh = &built
+		blas[m] = bvh
+	}
+	return bvh
+}
+
+// Emissive meshes stay in world space so that their primitives can be sampled as lights.
+// Meshes with a single primitive are not worth a bottom level BVH, meshes without material count as not emissive
+func (m Mesh) instancable() bool {
+	return len(m.geometry) > 1 && (m.material == nil || m.material.emittedLight().isBlack())
+}
+
 func (m Mesh) Transformed(t Matrix4) []tracable {
 	tracables := make([]tracable, len(m.geometry))
 	for i, prim := range m.geometry {
 		tracables[i] = tracable{
-			prim: prim.transformed(t),
-			mat:  m.material,
+			prim:  prim.transformed(t),
+			mat:   m.material,
+			index: i,
 		}
 	}
 	return tracables
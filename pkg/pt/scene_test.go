@@ -0,0 +1,89 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestRefit(t *testing.T) {
+	tests := []struct {
+		name    string
+		compile func(*Scene) BVH
+	}{
+		{"default", (*Scene).Compile},
+		{"instanced", (*Scene).CompileInstanced},
+		{"flattened", func(s *Scene) BVH {
+			bvh := s.CompileSAH(DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2)
+			bvh.Flatten(4)
+			return bvh
+		}},
+	}
+	for _, test := range tests {
+		r := rand.New(rand.NewSource(1))
+		scene := bvhTestScene(r)
+		rays := bvhTestRays(r, 2000)
+		bvh := test.compile(scene)
+		before := make([]hit, len(rays))
+		for i, ray := range rays {
+			bvh.intersected(ray, 0.0001, math.Inf(1), &before[i])
+		}
+
+		for i, node := range scene.root.children {
+			if i%2 == 0 {
+				node.Translate(r.Float64()-0.5, r.Float64()-0.5, r.Float64()-0.5)
+			}
+		}
+		refitted := scene.Refit(bvh, math.Inf(1), func(*Scene) BVH {
+			t.Fatalf("%v: moved nodes were rebuilt instead of refitted", test.name)
+			return BVH{}
+		})
+		if (refitted.wide != nil) != (bvh.wide != nil) {
+			t.Errorf("%v: refitting changed the layout of the BVH", test.name)
+		}
+		reference := scene.Compile()
+		expectSameHits(t, test.name, &reference, &refitted, rays)
+
+		// The refitted BVH is a copy
+		for i, ray := range rays {
+			h := hit{}
+			bvh.intersected(ray, 0.0001, math.Inf(1), &h)
+			if h.t != before[i].t || h.node != before[i].node {
+				t.Fatalf("%v: refitting changed the hit of ray %v in the original BVH", test.name, i)
+			}
+		}
+	}
+}
+
+func TestRefitRebuilds(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		change    func(*Scene)
+	}{
+		{"added node", math.Inf(1), func(s *Scene) {
+			s.Add(NewSceneNode(NewMesh(Geometry{NewSphere(NewVector3(0, 0, 0), 1)}, Diffuse{})))
+		}},
+		{"started moving", math.Inf(1), func(s *Scene) { s.root.children[0].SetMotion(Translate(1, 0, 0)) }},
+		{"cost exceeded", 1, func(s *Scene) {
+			// Moving all nodes onto each other makes the bounding boxes overlap
+			for _, node := range s.root.children {
+				node.ResetTransformation()
+			}
+		}},
+	}
+	for _, test := range tests {
+		r := rand.New(rand.NewSource(1))
+		scene := bvhTestScene(r)
+		bvh := scene.Compile()
+		test.change(scene)
+		rebuilt := false
+		scene.Refit(bvh, test.threshold, func(s *Scene) BVH {
+			rebuilt = true
+			return s.Compile()
+		})
+		if !rebuilt {
+			t.Errorf("%v: BVH was not rebuilt", test.name)
+		}
+	}
+}
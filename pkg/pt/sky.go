@@ -0,0 +1,167 @@
+package pt
+
+import (
+	"math"
+)
+
+const (
+	// Angular diameter of the sun as seen from earth in radians
+	SUN_ANGULAR_DIAMETER = 0.0093
+	// Resolution of the equirectangular image the sky is importance sampled with
+	SKY_SAMPLING_WIDTH  = 128
+	SKY_SAMPLING_HEIGHT = 64
+)
+
+// Analytic daylight model of Preetham et al., "A Practical Analytic Model for Daylight".
+// Radiance is given in kcd/m² scaled by Intensity, the sun itself is not included, see Sky.Sun
+type Sky struct {
+	Intensity float64
+
+	elevation float64
+	azimuth   float64
+	sun       Vector3
+	perez     [3][5]float64 // distribution coefficients of luminance Y and chromaticities x and y
+	zenith    [3]float64    // Y, x and y at the zenith
+	sunPerez  [3]float64    // distribution function at the zenith, used for normalization
+	sampling  *EnvironmentMap
+}
+
+// Creates the sky for the sun at elevation above the horizon and azimuth in radians.
+// The azimuth is measured from -z towards +x, turbidity ranges from 2 for clear skies to about 10 for haze
+func NewPreethamSky(elevation, azimuth, turbidity float64) *Sky {
+	s := &Sky{
+		Intensity: 1,
+		elevation: elevation,
+		azimuth:   azimuth,
+		sun:       sphericalDirection(elevation, azimuth),
+	}
+	t := turbidity
+	s.perez = [3][5]float64{
+		{0.1787*t - 1.4630, -0.3554*t + 0.4275, -0.0227*t + 5.3251, 0.1206*t - 2.5771, -0.0670*t + 0.3703},
+		{-0.0193*t - 0.2592, -0.0665*t + 0.0008, -0.0004*t + 0.2125, -0.0641*t - 0.8989, -0.0033*t + 0.0452},
+		{-0.0167*t - 0.2608, -0.0950*t + 0.0092, -0.0079*t + 0.2102, -0.0441*t - 1.6537, -0.0109*t + 0.0529},
+	}
+
+	theta := math.Pi/2 - math.Max(elevation, 0)
+	theta2 := theta * theta
+	theta3 := theta2 * theta
+	chi := (4.0/9.0 - t/120) * (math.Pi - 2*theta)
+	s.zenith[0] = (4.0453*t-4.9710)*math.Tan(chi) - 0.2155*t + 2.4192
+	s.zenith[1] = t*t*(0.00166*theta3-0.00375*theta2+0.00209*theta) +
+		t*(-0.02903*theta3+0.06377*theta2-0.03202*theta+0.00394) +
+		(0.11693*theta3 - 0.21196*theta2 + 0.06052*theta + 0.25886)
+	s.zenith[2] = t*t*(0.00275*theta3-0.00610*theta2+0.00317*theta) +
+		t*(-0.04214*theta3+0.08970*theta2-0.04153*theta+0.00516) +
+		(0.15346*theta3 - 0.26756*theta2 + 0.06670*theta + 0.26688)
+	for i := range s.sunPerez {
+		s.sunPerez[i] = perez(s.perez[i], 1, theta)
+	}
+
+	// The sky is sampled proportional to its radiance on a coarse image
+	pixels := make([]Color, SKY_SAMPLING_WIDTH*SKY_SAMPLING_HEIGHT)
+	for y := 0; y < SKY_SAMPLING_HEIGHT; y++ {
+		latitude := math.Pi/2 - math.Pi*(float64(y)+0.5)/SKY_SAMPLING_HEIGHT
+		for x := 0; x < SKY_SAMPLING_WIDTH; x++ {
+			phi := 2*math.Pi*(float64(x)+0.5)/SKY_SAMPLING_WIDTH - math.Pi
+			pixels[y*SKY_SAMPLING_WIDTH+x] = s.radiance(sphericalDirection(latitude, phi))
+		}
+	}
+	s.sampling = NewEnvironmentMap(SKY_SAMPLING_WIDTH, SKY_SAMPLING_HEIGHT, pixels)
+	return s
+}
+
+// Unit vector pointing towards the sun
+func (s *Sky) SunDirection() Vector3 {
+	return s.sun
+}
+
+// Directional light in the direction of the sun of the sky
+func (s *Sky) Sun(angularDiameter float64, radiance Color) DistantLight {
+	return NewSun(s.elevation, s.azimuth, angularDiameter, radiance)
+}
+
+func (s *Sky) radiance(direction Vector3) Color {
+	direction = direction.Unit()
+	// Directions below the horizon get the color of the horizon
+	cosTheta := math.Max(direction.Y, 0.001)
+	gamma := math.Acos(Clamp(direction.Dot(s.sun), -1, 1))
+	var xyY [3]float64
+	for i := range xyY {
+		xyY[i] = s.zenith[i] * perez(s.perez[i], cosTheta, gamma) / s.sunPerez[i]
+	}
+	return xyYToRGB(xyY[1], xyY[2], xyY[0]).Scale(s.Intensity)
+}
+
+func (s *Sky) sample(u, v float64) (Vector3, float64) {
+	return s.sampling.sample(u, v)
+}
+
+func (s *Sky) pdf(direction Vector3) float64 {
+	return s.sampling.pdf(direction)
+}
+
+// Perez distribution function for the zenith angle given by cosTheta and angle gamma to the sun
+func perez(c [5]float64, cosTheta, gamma float64) float64 {
+	cosGamma := math.Cos(gamma)
+	return (1 + c[0]*math.Exp(c[1]/cosTheta)) * (1 + c[2]*math.Exp(c[3]*gamma) + c[4]*cosGamma*cosGamma)
+}
+
+// Converts chromaticity x, y and luminance Y into linear sRGB
+func xyYToRGB(x, y, luminance float64) Color {
+	if y <= 0 {
+		return NewColor(0, 0, 0)
+	}
+	X := x / y * luminance
+	Z := (1 - x - y) / y * luminance
+	return NewColor(
+		math.Max(0, 3.2406*X-1.5372*luminance-0.4986*Z),
+		math.Max(0, -0.9689*X+1.8758*luminance+0.0415*Z),
+		math.Max(0, 0.0557*X-0.2040*luminance+1.0570*Z),
+	)
+}
+
+// Unit vector at elevation above the horizon and azimuth measured from -z towards +x
+func sphericalDirection(elevation, azimuth float64) Vector3 {
+	cosElevation := math.Cos(elevation)
+	return NewVector3(cosElevation*math.Sin(azimuth), math.Sin(elevation), -cosElevation*math.Cos(azimuth))
+}
+
+// Light from a distant disk like the sun, seen under the same solid angle from every point
+type DistantLight struct {
+	Direction       Vector3 // unit vector towards the light
+	Radiance        Color
+	AngularDiameter float64 // radians, must be greater than 0
+}
+
+// Sun at elevation above the horizon and azimuth measured from -z towards +x in radians, e.g. with SUN_ANGULAR_DIAMETER
+func NewSun(elevation, azimuth, angularDiameter float64, radiance Color) DistantLight {
+	return DistantLight{
+		Direction:       sphericalDirection(elevation, azimuth),
+		Radiance:        radiance,
+		AngularDiameter: angularDiameter,
+	}
+}
+
+func (l *DistantLight) cosMax() float64 {
+	return math.Cos(l.AngularDiameter / 2)
+}
+
+// Whether the unit vector direction points into the disk of the light
+func (l *DistantLight) visible(direction Vector3) bool {
+	return direction.Dot(l.Direction) >= l.cosMax()
+}
+
+// Samples a direction uniformly within the cone of the light, returns the direction and its pdf with respect to solid angle
+func (l *DistantLight) sample(u, v float64) (Vector3, float64) {
+	cosMax := l.cosMax()
+	cosTheta := 1 - u*(1-cosMax)
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * v
+	frame := newShadingFrame(l.Direction)
+	direction := frame.toWorld(NewVector3(sinTheta*math.Cos(phi), sinTheta*math.Sin(phi), cosTheta))
+	return direction, l.pdf()
+}
+
+func (l *DistantLight) pdf() float64 {
+	return 1 / (2 * math.Pi * (1 - l.cosMax()))
+}
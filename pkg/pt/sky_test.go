@@ -0,0 +1,92 @@
+package pt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSphericalDirection(t *testing.T) {
+	tests := []struct {
+		elevation, azimuth float64
+		expected           Vector3
+	}{
+		{0, 0, NewVector3(0, 0, -1)},
+		{0, math.Pi / 2, NewVector3(1, 0, 0)},
+		{math.Pi / 2, 1, NewVector3(0, 1, 0)},
+		{math.Pi / 4, math.Pi, NewVector3(0, math.Sqrt2/2, math.Sqrt2/2)},
+	}
+	for _, test := range tests {
+		if got := sphericalDirection(test.elevation, test.azimuth); got.Sub(test.expected).Length() > 1e-12 {
+			t.Errorf("elevation %v and azimuth %v gave %v, expected %v", test.elevation, test.azimuth, got, test.expected)
+		}
+	}
+}
+
+func TestPreethamSky(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, turbidity := range []float64{2, 3, 10} {
+		for _, elevation := range []float64{0.05, 0.4, 1.4} {
+			sky := NewPreethamSky(elevation, 0.8, turbidity)
+			if expected := sphericalDirection(elevation, 0.8); sky.SunDirection() != expected {
+				t.Errorf("sun direction is %v, expected %v", sky.SunDirection(), expected)
+			}
+			for i := 0; i < 1000; i++ {
+				c := sky.radiance(RandomUnitVector(r))
+				if c.X < 0 || c.Y < 0 || c.Z < 0 || math.IsNaN(c.X+c.Y+c.Z) || math.IsInf(c.X+c.Y+c.Z, 0) {
+					t.Fatalf("turbidity %v, elevation %v: radiance %v", turbidity, elevation, c)
+				}
+			}
+			// Circumsolar sky is brighter than the sky opposite the sun
+			opposite := sky.SunDirection().Mul(-1)
+			opposite.Y = -opposite.Y
+			if luminance(sky.radiance(sky.SunDirection())) <= luminance(sky.radiance(opposite)) {
+				t.Errorf("turbidity %v, elevation %v: sky around the sun is not brighter than opposite of it", turbidity, elevation)
+			}
+			for i := 0; i < 1000; i++ {
+				direction, pdf := sky.sample(r.Float64(), r.Float64())
+				if expected := sky.pdf(direction); pdf <= 0 || math.Abs(pdf-expected) > 1e-6*expected {
+					t.Fatalf("turbidity %v, elevation %v: sampled %v with pdf %v, expected %v", turbidity, elevation, direction, pdf, expected)
+				}
+			}
+		}
+	}
+	sky := NewPreethamSky(0.4, 0.8, 3)
+	direction := NewVector3(0.3, 0.5, -1)
+	expected := sky.radiance(direction).Scale(2.5)
+	sky.Intensity = 2.5
+	if got := sky.radiance(direction); Vector3(got).Sub(Vector3(expected)).Length() > 1e-9 {
+		t.Errorf("radiance with intensity is %v, expected %v", got, expected)
+	}
+}
+
+func TestDistantLight(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	light := NewSun(0.3, 2, 0.2, NewColor(1, 1, 1))
+	if !light.visible(light.Direction) || light.visible(light.Direction.Mul(-1)) {
+		t.Error("visibility of the disk is wrong")
+	}
+	const samples = 10000
+	mean := Vector3{}
+	for i := 0; i < samples; i++ {
+		direction, pdf := light.sample(r.Float64(), r.Float64())
+		if math.Abs(direction.Length()-1) > 1e-9 {
+			t.Fatalf("sampled direction %v is not a unit vector", direction)
+		}
+		if direction.Dot(light.Direction) < light.cosMax()-1e-9 {
+			t.Fatalf("sampled direction %v is outside of the disk", direction)
+		}
+		if pdf != light.pdf() {
+			t.Fatalf("sampled pdf %v, expected %v", pdf, light.pdf())
+		}
+		mean = mean.Add(direction)
+	}
+	if mean.Unit().Dot(light.Direction) < 1-1e-4 {
+		t.Errorf("samples are centered around %v, expected %v", mean.Unit(), light.Direction)
+	}
+	// Uniform over the cone, so the pdf is the inverse of its solid angle
+	solidAngle := 2 * math.Pi * (1 - math.Cos(0.1))
+	if math.Abs(light.pdf()*solidAngle-1) > 1e-9 {
+		t.Errorf("pdf %v does not match the solid angle %v", light.pdf(), solidAngle)
+	}
+}
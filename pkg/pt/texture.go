@@ -0,0 +1,157 @@
+package pt
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+)
+
+// Texture coordinates
+type texCoord struct {
+	u float64
+	v float64
+}
+
+func (t texCoord) add(o texCoord) texCoord {
+	return texCoord{t.u + o.u, t.v + o.v}
+}
+
+func (t texCoord) mul(factor float64) texCoord {
+	return texCoord{t.u * factor, t.v * factor}
+}
+
+type Texture interface {
+	// Color at texture coordinates u,v with (0,0) being the bottom left corner
+	At(u, v float64) Color
+}
+
+// Returns the texture color at the hit point, or the constant color if no texture is set
+func albedo(constant Color, texture Texture, h *hit) Color {
+	if texture == nil {
+		return constant
+	}
+	return texture.At(h.uv.u, h.uv.v)
+}
+
+// Determines how texture coordinates outside of [0,1] are handled
+type WrapMode int
+
+const (
+	WrapRepeat WrapMode = iota
+	WrapClamp
+	WrapMirror
+)
+
+// Image backed texture using bilinear filtering
+type ImageTexture struct {
+	Wrap   WrapMode
+	width  int
+	height int
+	texels []Color // linear colors, row major starting at the top left corner
+}
+
+// Creates a texture from the image, an empty image results in a black texture
+func NewImageTexture(img image.Image, wrap WrapMode) *ImageTexture {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return &ImageTexture{Wrap: wrap, width: 1, height: 1, texels: []Color{{}}}
+	}
+	tex := &ImageTexture{
+		Wrap:   wrap,
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		texels: make([]Color, bounds.Dx()*bounds.Dy()),
+	}
+	for y := 0; y < tex.height; y++ {
+		for x := 0; x < tex.width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			tex.texels[y*tex.width+x] = linearColor(r, g, b)
+		}
+	}
+	return tex
+}
+
+// Decodes a PNG or JPEG image into a texture, panics on error. Use LoadImageTextureFromPath to handle errors
+func NewImageTextureFromPath(path string, wrap WrapMode) *ImageTexture {
+	tex, err := LoadImageTextureFromPath(path, wrap)
+	if err != nil {
+		panic(err)
+	}
+	return tex
+}
+
+// Decodes a PNG or JPEG image into a texture
+func LoadImageTextureFromPath(path string, wrap WrapMode) (*ImageTexture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return NewImageTexture(img, wrap), nil
+}
+
+func (t *ImageTexture) At(u, v float64) Color {
+	// Texel centers are located at half integer coordinates
+	x := u*float64(t.width) - 0.5
+	y := (1-v)*float64(t.height) - 0.5
+	x0 := math.Floor(x)
+	y0 := math.Floor(y)
+	dx := x - x0
+	dy := y - y0
+
+	c00 := t.texel(int(x0), int(y0))
+	c10 := t.texel(int(x0)+1, int(y0))
+	c01 := t.texel(int(x0), int(y0)+1)
+	c11 := t.texel(int(x0)+1, int(y0)+1)
+	top := c00.Scale(1 - dx).Add(c10.Scale(dx))
+	bottom := c01.Scale(1 - dx).Add(c11.Scale(dx))
+	return top.Scale(1 - dy).Add(bottom.Scale(dy))
+}
+
+func (t *ImageTexture) texel(x, y int) Color {
+	x = wrap(x, t.width, t.Wrap)
+	y = wrap(y, t.height, t.Wrap)
+	return t.texels[y*t.width+x]
+}
+
+// Maps index i into [0,n) according to the wrap mode
+func wrap(i, n int, mode WrapMode) int {
+	switch mode {
+	case WrapClamp:
+		if i < 0 {
+			return 0
+		}
+		if i >= n {
+			return n - 1
+		}
+		return i
+	case WrapMirror:
+		period := 2 * n
+		i %= period
+		if i < 0 {
+			i += period
+		}
+		if i >= n {
+			i = period - 1 - i
+		}
+		return i
+	default:
+		i %= n
+		if i < 0 {
+			i += n
+		}
+		return i
+	}
+}
+
+// Converts 16 bit sRGB encoded color channels into linear colors
+func linearColor(r, g, b uint32) Color {
+	return NewColor(srgbDecode(float64(r)/0xffff), srgbDecode(float64(g)/0xffff), srgbDecode(float64(b)/0xffff))
+}
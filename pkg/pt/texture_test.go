@@ -0,0 +1,90 @@
+package pt
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		mode     WrapMode
+		indeces  []int
+		expected []int
+	}{
+		{WrapRepeat, []int{-5, -1, 0, 2, 3, 4, 7}, []int{1, 2, 0, 2, 0, 1, 1}},
+		{WrapClamp, []int{-5, -1, 0, 2, 3, 4, 7}, []int{0, 0, 0, 2, 2, 2, 2}},
+		{WrapMirror, []int{-5, -1, 0, 2, 3, 4, 7}, []int{1, 0, 0, 2, 2, 1, 1}},
+	}
+	for _, test := range tests {
+		for i, index := range test.indeces {
+			if got := wrap(index, 3, test.mode); got != test.expected[i] {
+				t.Errorf("mode %v: wrapped %v to %v, expected %v", test.mode, index, got, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestImageTexture(t *testing.T) {
+	// 2x2 image with black and white texels in the top row and gray ones in the bottom row
+	img := image.NewGray(image.Rect(10, 10, 12, 12))
+	img.SetGray(10, 10, color.Gray{0})
+	img.SetGray(11, 10, color.Gray{255})
+	img.SetGray(10, 11, color.Gray{128})
+	img.SetGray(11, 11, color.Gray{128})
+	gray := srgbDecode(128.0 / 255)
+
+	tests := []struct {
+		mode     WrapMode
+		u, v     float64
+		expected float64
+	}{
+		{WrapClamp, 0.25, 0.75, 0},
+		{WrapClamp, 0.75, 0.75, 1},
+		{WrapClamp, 0.25, 0.25, gray},
+		{WrapClamp, 0.5, 0.75, 0.5},
+		{WrapClamp, 0.5, 0.5, (0.5 + gray) / 2},
+		{WrapClamp, 0, 1, 0},
+		{WrapRepeat, 0, 0.75, 0.5},
+		{WrapRepeat, 1.25, 1.75, 0},
+		{WrapMirror, 0, 0.75, 0},
+		{WrapMirror, -0.25, 0.75, 0},
+	}
+	for _, test := range tests {
+		tex := NewImageTexture(img, test.mode)
+		got := tex.At(test.u, test.v)
+		if math.Abs(got.X-test.expected) > 1e-9 || got.X != got.Y || got.X != got.Z {
+			t.Errorf("mode %v: texture at %v,%v is %v, expected %v", test.mode, test.u, test.v, got, test.expected)
+		}
+	}
+}
+
+func TestImageTextureEmpty(t *testing.T) {
+	for _, mode := range []WrapMode{WrapRepeat, WrapClamp, WrapMirror} {
+		tex := NewImageTexture(image.NewRGBA(image.Rect(0, 0, 0, 0)), mode)
+		if got := tex.At(0.3, 0.7); !got.isBlack() {
+			t.Errorf("mode %v: empty texture is %v", mode, got)
+		}
+	}
+}
+
+func TestOBJTexCoords(t *testing.T) {
+	obj := "v 0 0 0\nv 1 0 0\nv 0 1 0\nvt 0 0\nvt 1 0\nvt 0.5\nf 1/1 2/2 3/3\nf 1 2/2 3/3\n"
+	geometry, err := LoadOBJ(strings.NewReader(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(geometry) != 2 {
+		t.Fatalf("got %v triangles, expected 2", len(geometry))
+	}
+	tri := geometry[0].(*Triangle)
+	if uv := tri.texCoord(0.5, 0.5); uv != (texCoord{0.75, 0}) {
+		t.Errorf("texture coordinates at the center of the second edge are %v", uv)
+	}
+	// Faces that do not specify coordinates for all vertecies are not textured
+	if uv := geometry[1].(*Triangle).texCoord(0.5, 0.5); uv != (texCoord{}) {
+		t.Errorf("partially textured face has texture coordinates %v", uv)
+	}
+}
@@ -0,0 +1,133 @@
+package pt
+
+import (
+	"image/color"
+	"math"
+)
+
+// Maps linear high dynamic range colors into range [0,1]
+type ToneMapper func(Color) Color
+
+// Clips all values above 1
+func ClampToneMapper(c Color) Color {
+	return NewColor(Clamp(c.X, 0, 1), Clamp(c.Y, 0, 1), Clamp(c.Z, 0, 1))
+}
+
+// Reinhard operator c / (1 + c) applied per channel
+func ReinhardToneMapper(c Color) Color {
+	return NewColor(reinhard(c.X), reinhard(c.Y), reinhard(c.Z))
+}
+
+func reinhard(x float64) float64 {
+	x = math.Max(0, x)
+	return x / (1 + x)
+}
+
+// Fit of the ACES filmic reference rendering transform by Krzysztof Narkowicz
+func ACESToneMapper(c Color) Color {
+	return NewColor(aces(c.X), aces(c.Y), aces(c.Z))
+}
+
+func aces(x float64) float64 {
+	x = math.Max(0, x)
+	return Clamp((x*(2.51*x+0.03))/(x*(2.43*x+0.59)+0.14), 0, 1)
+}
+
+// Filmic operator of Uncharted 2 by John Hable, with a linear white point of 11.2
+func Uncharted2ToneMapper(c Color) Color {
+	white := uncharted2(11.2)
+	return NewColor(
+		Clamp(uncharted2(2*c.X)/white, 0, 1),
+		Clamp(uncharted2(2*c.Y)/white, 0, 1),
+		Clamp(uncharted2(2*c.Z)/white, 0, 1),
+	)
+}
+
+func uncharted2(x float64) float64 {
+	const (
+		shoulder = 0.15
+		linear   = 0.50
+		angle    = 0.10
+		toe      = 0.20
+		toeNum   = 0.02
+		toeDenom = 0.30
+	)
+	x = math.Max(0, x)
+	return (x*(shoulder*x+angle*linear)+toe*toeNum)/(x*(shoulder*x+linear)+toe*toeDenom) - toeNum/toeDenom
+}
+
+// Converts linear radiance of a buffer into displayable 8 bit sRGB colors
+type PostProcess struct {
+	Exposure float64 // in stops, colors are scaled by 2^Exposure before tone mapping
+	ToneMap  ToneMapper
+	Dither   bool // adds triangular noise of one quantization step to hide banding
+}
+
+func DefaultPostProcess() PostProcess {
+	return PostProcess{
+		Exposure: 0,
+		ToneMap:  ClampToneMapper,
+		Dither:   false,
+	}
+}
+
+// Applies the post process to the linear color c of pixel x,y
+func (p PostProcess) apply(c Color, x, y int) color.RGBA {
+	c = c.Scale(math.Exp2(p.Exposure))
+	if p.ToneMap != nil {
+		c = p.ToneMap(c)
+	}
+	noise := [3]float64{}
+	if p.Dither {
+		noise = ditherNoise(x, y)
+	}
+	return color.RGBA{
+		R: quantize(srgbEncode(c.X), noise[0]),
+		G: quantize(srgbEncode(c.Y), noise[1]),
+		B: quantize(srgbEncode(c.Z), noise[2]),
+		A: 255,
+	}
+}
+
+func quantize(v float64, noise float64) uint8 {
+	return uint8(Clamp(math.Round(v*255+noise), 0, 255))
+}
+
+// Deterministic triangular distributed noise in range (-1,1) per channel of pixel x,y
+func ditherNoise(x, y int) [3]float64 {
+	h := uint32(x)*0x8da6b343 ^ uint32(y)*0xd8163841
+	var noise [3]float64
+	for i := range noise {
+		h1 := hash32(h + uint32(2*i))
+		h2 := hash32(h + uint32(2*i+1))
+		noise[i] = float64(h1)/math.MaxUint32 + float64(h2)/math.MaxUint32 - 1
+	}
+	return noise
+}
+
+// Integer hash by Chris Wellons
+func hash32(x uint32) uint32 {
+	x ^= x >> 16
+	x *= 0x7feb352d
+	x ^= x >> 15
+	x *= 0x846ca68b
+	x ^= x >> 16
+	return x
+}
+
+// Linear to sRGB transfer function
+func srgbEncode(v float64) float64 {
+	v = Clamp(v, 0, 1)
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// sRGB to linear transfer function
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
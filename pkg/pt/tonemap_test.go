@@ -0,0 +1,108 @@
+package pt
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToneMappers(t *testing.T) {
+	tests := []struct {
+		name   string
+		mapper ToneMapper
+		points map[float64]float64
+	}{
+		{"clamp", ClampToneMapper, map[float64]float64{-1: 0, 0: 0, 0.25: 0.25, 1: 1, 4: 1}},
+		{"reinhard", ReinhardToneMapper, map[float64]float64{-1: 0, 0: 0, 1: 0.5, 3: 0.75}},
+		{"aces", ACESToneMapper, map[float64]float64{-1: 0, 0: 0, 100: 1}},
+		{"uncharted2", Uncharted2ToneMapper, map[float64]float64{-1: 0, 0: 0, 5.6: 1, 100: 1}},
+	}
+	for _, test := range tests {
+		for x, expected := range test.points {
+			if got := test.mapper(NewColor(x, x, x)); math.Abs(got.X-expected) > 1e-9 || got.X != got.Y || got.X != got.Z {
+				t.Errorf("%v: mapped %v to %v, expected %v", test.name, x, got, expected)
+			}
+		}
+		// Channels are mapped independently, monotonically into [0,1]
+		previous := 0.0
+		for x := 0.0; x < 50; x += 0.01 {
+			got := test.mapper(NewColor(x, 0, 2*x))
+			if got.X < previous || got.X < 0 || got.X > 1 || got.Y != test.mapper(NewColor(0, 0, 0)).Y || got.Z != test.mapper(NewColor(2*x, 0, 0)).X {
+				t.Fatalf("%v: mapped %v to %v after %v", test.name, x, got, previous)
+			}
+			previous = got.X
+		}
+	}
+}
+
+func TestSRGB(t *testing.T) {
+	tests := []struct{ linear, encoded float64 }{
+		{0, 0},
+		{0.002, 0.02584},
+		{0.2140, 0.5},
+		{1, 1},
+	}
+	for _, test := range tests {
+		if got := srgbEncode(test.linear); math.Abs(got-test.encoded) > 1e-4 {
+			t.Errorf("encoded %v to %v, expected %v", test.linear, got, test.encoded)
+		}
+		if got := srgbDecode(test.encoded); math.Abs(got-test.linear) > 1e-4 {
+			t.Errorf("decoded %v to %v, expected %v", test.encoded, got, test.linear)
+		}
+	}
+	for i := 0; i <= 255; i++ {
+		v := float64(i) / 255
+		if got := srgbEncode(srgbDecode(v)); math.Abs(got-v) > 1e-12 {
+			t.Errorf("%v does not survive a round trip, got %v", v, got)
+		}
+	}
+}
+
+func TestPostProcess(t *testing.T) {
+	post := DefaultPostProcess()
+	tests := []struct {
+		name     string
+		exposure float64
+		color    Color
+		expected uint8
+	}{
+		{"black", 0, NewColor(0, 0, 0), 0},
+		{"gray", 0, NewColor(0.216, 0.216, 0.216), 128},
+		{"exposed", 1, NewColor(0.108, 0.108, 0.108), 128},
+		{"underexposed", -2, NewColor(4, 4, 4), 255},
+		{"clipped", 0, NewColor(10, 10, 10), 255},
+		{"negative", 0, NewColor(-1, -1, -1), 0},
+	}
+	for _, test := range tests {
+		post.Exposure = test.exposure
+		got := post.apply(test.color, 0, 0)
+		if got.R != test.expected || got.G != test.expected || got.B != test.expected || got.A != 255 {
+			t.Errorf("%v: got %v, expected %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestDither(t *testing.T) {
+	post := DefaultPostProcess()
+	post.Dither = true
+	c := NewColor(0.3, 0.3, 0.3)
+	undithered := DefaultPostProcess().apply(c, 0, 0)
+	sum := 0.0
+	const size = 64
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			got := post.apply(c, x, y)
+			if got != post.apply(c, x, y) {
+				t.Fatalf("dithering of pixel %v,%v is not deterministic", x, y)
+			}
+			if d := int(got.R) - int(undithered.R); d < -1 || d > 1 {
+				t.Fatalf("pixel %v,%v dithered by %v steps", x, y, d)
+			}
+			sum += float64(got.R)
+		}
+	}
+	// The mean is preserved
+	expected := srgbEncode(c.X) * 255
+	if mean := sum / (size * size); math.Abs(mean-expected) > 0.05 {
+		t.Errorf("mean of the dithered pixels is %v, expected %v", mean, expected)
+	}
+}
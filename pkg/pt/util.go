@@ -98,3 +98,24 @@ func MaxVec(a Vector3, b Vector3) Vector3 {
 	}
 	return NewVector3(x, y, z)
 }
+
+// Computes two unit vectors, that form an orthonormal basis together with the unit vector w
+func orthonormalBasis(w Vector3) (Vector3, Vector3) {
+	a := NewVector3(1, 0, 0)
+	if math.Abs(w.X) > 0.9 {
+		a = NewVector3(0, 1, 0)
+	}
+	v := w.Cross(a).Unit()
+	u := w.Cross(v)
+	return u, v
+}
+
+// Power heuristic with beta = 2 used to weight samples in multiple importance sampling
+func powerHeuristic(pdf float64, otherPdf float64) float64 {
+	a := pdf * pdf
+	b := otherPdf * otherPdf
+	if a+b == 0 {
+		return 0
+	}
+	return a / (a + b)
+}
@@ -0,0 +1,269 @@
+package pt
+
+import (
+	"math"
+)
+
+const (
+	// Capacity of the traversal stack that does not need to be allocated on the heap
+	WIDE_STACK_SIZE = 256
+	// Count of slots without child
+	WIDE_EMPTY_SLOT = -1
+)
+
+// BVH collapsed into a contiguous array of nodes with up to width children each.
+// Child bounds are stored as structure of arrays, leaf primitives are reordered to be contiguous
+type wideBVH struct {
+	width int
+	// Per node 6*width values: min x of all children, then min y, min z, max x, max y and max z
+	bounds []float64
+	// Per node width values: index of the child node, or of the first primitive for leaves
+	children []int32
+	// Per node width values: number of primitives of leaf children, 0 for inner nodes and WIDE_EMPTY_SLOT for unused slots
+	counts []int32
+	prims  []tracable
+}
+
+// Collapses the BVH into a flattened tree with 4 or 8 children per node, which is used for all further intersection tests.
+// Nodes with more children than width are split into intermediate nodes.
+// Bottom level BVHs of instances and moving instances are flattened as well. Refitting flattens the BVH again
+func (bvh *BVH) Flatten(width int) {
+	if width != 4 && width != 8 {
+		panic("BVH can only be flattened to a width of 4 or 8")
+	}
+	if bvh.root == nil {
+		// Nothing to traverse
+		return
+	}
+	wide := &wideBVH{
+		width: width,
+		prims: make([]tracable, 0, len(bvh.prims)),
+	}
+	if bvh.root.isLeaf {
+		// Wrap a single leaf into a node with one child
+		node := wide.addNode()
+		wide.setLeaf(node, 0, bvh.root, bvh.prims)
+	} else {
+		wide.collapse(bvh.root, bvh.prims)
+	}
+	bvh.wide = wide
+
+	for _, prim := range bvh.prims {
+		var blas *BVH
+		switch inst := prim.prim.(type) {
+		case *instance:
+			blas = inst.blas
+		case *movingInstance:
+			blas = inst.blas
+		default:
+			continue
+		}
+		if blas.wide == nil || blas.wide.width != width {
+			blas.Flatten(width)
+		}
+	}
+}
+
+// Appends a node with empty slots and returns its index
+func (w *wideBVH) addNode() int {
+	index := len(w.children) / w.width
+	for i := 0; i < 6*w.width; i++ {
+		w.bounds = append(w.bounds, 0)
+	}
+	for i := 0; i < w.width; i++ {
+		w.children = append(w.children, 0)
+		w.counts = append(w.counts, WIDE_EMPTY_SLOT)
+	}
+	return index
+}
+
+// Creates a wide node for the branch node and returns its index
+func (w *wideBVH) collapse(node *bvhNode, prims []tracable) int {
+	// Replace the child with the biggest surface by its own children as long as they fit
+	children := append([]*bvhNode{}, node.children...)
+	for len(children) < w.width {
+		best := -1
+		for i, child := range children {
+			if child.isLeaf || len(children)-1+len(child.children) > w.width {
+				continue
+			}
+			if best < 0 || child.bounding.surface() > children[best].bounding.surface() {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+		expanded := children[best].children
+		children = append(children[:best], children[best+1:]...)
+		children = append(children, expanded...)
+	}
+	if len(children) > w.width {
+		children = w.group(children)
+	}
+
+	index := w.addNode()
+	for i, child := range children {
+		if child.isLeaf {
+			w.setLeaf(index, i, child, prims)
+			continue
+		}
+		w.setBounds(index, i, child.bounding)
+		childIndex := w.collapse(child, prims)
+		w.children[index*w.width+i] = int32(childIndex)
+		w.counts[index*w.width+i] = 0
+	}
+	return index
+}
+
+// Splits children of a node with a higher branching factor than width into width groups of neighbouring children.
+// Groups with more than one child become intermediate nodes, which are collapsed again
+func (w *wideBVH) group(children []*bvhNode) []*bvhNode {
+	groups := make([]*bvhNode, 0, w.width)
+	for i := 0; i < w.width; i++ {
+		part := children[i*len(children)/w.width : (i+1)*len(children)/w.width]
+		if len(part) == 1 {
+			groups = append(groups, part[0])
+			continue
+		}
+		// Not linked as parent, the source BVH stays unchanged
+		node := &bvhNode{children: part, bounding: part[0].bounding}
+		for _, child := range part[1:] {
+			node.bounding = node.bounding.add(child.bounding)
+		}
+		groups = append(groups, node)
+	}
+	return groups
+}
+
+func (w *wideBVH) setLeaf(node, slot int, leaf *bvhNode, prims []tracable) {
+	w.setBounds(node, slot, leaf.bounding)
+	w.children[node*w.width+slot] = int32(len(w.prims))
+	w.counts[node*w.width+slot] = int32(len(leaf.prims))
+	for _, prim := range leaf.prims {
+		w.prims = append(w.prims, prims[prim])
+	}
+}
+
+func (w *wideBVH) setBounds(node, slot int, box aabb) {
+	base := node * 6 * w.width
+	w.bounds[base+slot] = box.bounds[0].X
+	w.bounds[base+w.width+slot] = box.bounds[0].Y
+	w.bounds[base+2*w.width+slot] = box.bounds[0].Z
+	w.bounds[base+3*w.width+slot] = box.bounds[1].X
+	w.bounds[base+4*w.width+slot] = box.bounds[1].Y
+	w.bounds[base+5*w.width+slot] = box.bounds[1].Z
+}
+
+// Distance to the bounding box of the child in slot, false if the box is missed within tMin and tMax
+func (w *wideBVH) childDistance(ray *ray, base, slot int, tMin, tMax float64) (float64, bool) {
+	b := w.bounds[base:]
+	width := w.width
+	tx0 := (b[slot] - ray.origin.X) * ray.invDirection.X
+	tx1 := (b[3*width+slot] - ray.origin.X) * ray.invDirection.X
+	if tx0 > tx1 {
+		tx0, tx1 = tx1, tx0
+	}
+	ty0 := (b[width+slot] - ray.origin.Y) * ray.invDirection.Y
+	ty1 := (b[4*width+slot] - ray.origin.Y) * ray.invDirection.Y
+	if ty0 > ty1 {
+		ty0, ty1 = ty1, ty0
+	}
+	tz0 := (b[2*width+slot] - ray.origin.Z) * ray.invDirection.Z
+	tz1 := (b[5*width+slot] - ray.origin.Z) * ray.invDirection.Z
+	if tz0 > tz1 {
+		tz0, tz1 = tz1, tz0
+	}
+	near := math.Max(tMin, math.Max(tx0, math.Max(ty0, tz0)))
+	far := math.Min(tMax, math.Min(tx1, math.Min(ty1, tz1)))
+	return near, near <= far
+}
+
+func (w *wideBVH) intersected(ray ray, tMin, tMax float64, hitOut *hit) bool {
+	hitOut.t = tMax
+	didHit := false
+	var stackArray [WIDE_STACK_SIZE]int32
+	stack := append(stackArray[:0], 0)
+	var slots [8]int
+	var distances [8]float64
+	for len(stack) > 0 {
+		node := int(stack[len(stack)-1])
+		stack = stack[:len(stack)-1]
+		base := node * 6 * w.width
+
+		// Collect the intersected children sorted front to back
+		n := 0
+		for slot := 0; slot < w.width; slot++ {
+			if w.counts[node*w.width+slot] == WIDE_EMPTY_SLOT {
+				break
+			}
+			distance, ok := w.childDistance(&ray, base, slot, tMin, hitOut.t)
+			if !ok {
+				continue
+			}
+			i := n
+			for ; i > 0 && distances[i-1] > distance; i-- {
+				slots[i] = slots[i-1]
+				distances[i] = distances[i-1]
+			}
+			slots[i] = slot
+			distances[i] = distance
+			n++
+		}
+
+		// Leaves are intersected right away, nearest first, to shrink hitOut.t early
+		for i := 0; i < n; i++ {
+			slot := node*w.width + slots[i]
+			count := int(w.counts[slot])
+			if count <= 0 || distances[i] > hitOut.t {
+				continue
+			}
+			first := int(w.children[slot])
+			for p := first; p < first+count; p++ {
+				if w.prims[p].intersected(ray, tMin, hitOut.t, hitOut) {
+					didHit = true
+				}
+			}
+		}
+		// Push the farthest child first, so that the nearest is traversed next
+		for i := n - 1; i >= 0; i-- {
+			slot := node*w.width + slots[i]
+			if w.counts[slot] == 0 && distances[i] <= hitOut.t {
+				stack = append(stack, w.children[slot])
+			}
+		}
+	}
+	return didHit
+}
+
+// Any hit traversal, children are visited in slot order as the first hit ends the search
+func (w *wideBVH) occluded(ray ray, tMin, tMax float64) bool {
+	var stackArray [WIDE_STACK_SIZE]int32
+	stack := append(stackArray[:0], 0)
+	for len(stack) > 0 {
+		node := int(stack[len(stack)-1])
+		stack = stack[:len(stack)-1]
+		base := node * 6 * w.width
+		for slot := 0; slot < w.width; slot++ {
+			index := node*w.width + slot
+			count := int(w.counts[index])
+			if count == WIDE_EMPTY_SLOT {
+				break
+			}
+			if _, ok := w.childDistance(&ray, base, slot, tMin, tMax); !ok {
+				continue
+			}
+			if count == 0 {
+				stack = append(stack, w.children[index])
+				continue
+			}
+			first := int(w.children[index])
+			for p := first; p < first+count; p++ {
+				if w.prims[p].prim.occluded(ray, tMin, tMax) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package pt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFlattenHighBranchingFactor(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	scene := NewScene()
+	for i := 0; i < 2000; i++ {
+		center := NewVector3(r.Float64()*20-10, r.Float64()*20-10, r.Float64()*20-10)
+		scene.Add(NewSceneNode(NewMesh(Geometry{NewSphere(center, 0.1+r.Float64()*0.2)}, Diffuse{})))
+	}
+	compile := map[string]func() BVH{
+		"SAH 16": func() BVH { return scene.CompileSAH(DEFAULT_SAH_BINS, 1, 16) },
+		"SAH 8":  func() BVH { return scene.CompileSAH(DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 8) },
+		"PHR 8":  func() BVH { return scene.CompilePHR(0.5, 6, 8) },
+	}
+	for name, build := range compile {
+		for _, width := range []int{4, 8} {
+			reference := build()
+			flat := build()
+			flat.Flatten(width)
+			for i := 0; i < 1000; i++ {
+				origin := NewVector3(r.Float64()*30-15, r.Float64()*30-15, 15)
+				direction := NewVector3(r.Float64()-0.5, r.Float64()-0.5, -1)
+				expected, expectedOk := reference.Intersect(origin, direction, 0, 100)
+				got, ok := flat.Intersect(origin, direction, 0, 100)
+				if ok != expectedOk || got.Distance != expected.Distance || got.Node != expected.Node {
+					t.Fatalf("%v flattened to %v: ray %v hit %v at %v, expected %v at %v", name, width, i, ok, got.Distance, expectedOk, expected.Distance)
+				}
+				if flat.Occluded(origin, direction, 100) != expectedOk {
+					t.Fatalf("%v flattened to %v: occlusion of ray %v differs from the closest hit", name, width, i)
+				}
+			}
+		}
+	}
+}
+
+func TestFlattenEmpty(t *testing.T) {
+	for name, bvh := range map[string]BVH{"zero": {}, "SAH": NewScene().CompileSAH(DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2)} {
+		bvh.Flatten(4)
+		if bvh.root != nil {
+			if _, ok := bvh.Intersect(NewVector3(0, 0, 1), NewVector3(0, 0, -1), 0, 10); ok {
+				t.Errorf("%v: empty BVH was hit", name)
+			}
+		}
+	}
+}
+
+func TestFlattenMovingInstances(t *testing.T) {
+	scene := NewScene()
+	mesh := NewMesh(Geometry{
+		NewTriangleWithoutNormals(NewVector3(-1, -1, 0), NewVector3(1, -1, 0), NewVector3(0, 1, 0)),
+		NewSphere(NewVector3(0, 0, -1), 0.5),
+	}, Diffuse{})
+	node := NewSceneNode(mesh)
+	node.SetMotion(Translate(1, 0, 0))
+	scene.Add(node)
+	reference := scene.Compile()
+	flat := scene.Compile()
+	flat.Flatten(4)
+
+	blas := flat.prims[0].prim.(*movingInstance).blas
+	if blas.wide == nil || blas.wide.width != 4 {
+		t.Fatal("bottom level BVH of the moving instance was not flattened")
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		origin := NewVector3(r.Float64()*4-2, r.Float64()*4-2, 5)
+		ray := newRay(origin, NewVector3(0, 0, -1))
+		ray.time = r.Float64()
+		expected, got := hit{}, hit{}
+		expectedOk := reference.intersected(ray, 0, 100, &expected)
+		ok := flat.intersected(ray, 0, 100, &got)
+		if ok != expectedOk || got.t != expected.t {
+			t.Fatalf("ray %v hit %v at %v, expected %v at %v", i, ok, got.t, expectedOk, expected.t)
+		}
+	}
+}
+
+func TestFlattenMatchesBaseline(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	scene := bvhTestScene(r)
+	reference := scene.Compile()
+	rays := bvhTestRays(r, 2000)
+	compile := []struct {
+		name  string
+		build func() BVH
+	}{
+		{"default", scene.Compile},
+		{"instanced", scene.CompileInstanced},
+		{"LBVH", scene.CompileLBVH},
+		{"SBVH", func() BVH {
+			return scene.CompileSBVH(DEFAULT_SAH_BINS, DEFAULT_SAH_LEAF_SIZE, 2, DEFAULT_SBVH_DUPLICATION)
+		}},
+	}
+	for _, c := range compile {
+		for _, width := range []int{4, 8} {
+			bvh := c.build()
+			bvh.Flatten(width)
+			expectSameHits(t, c.name, &reference, &bvh, rays)
+		}
+	}
+}